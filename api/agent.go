@@ -1,6 +1,8 @@
 package api
 
 import (
+	"agent/api/backup"
+	"agent/api/notify"
 	"agent/api/services"
 	"github.com/getsentry/sentry-go"
 	"github.com/go-ozzo/ozzo-routing/v2"
@@ -26,6 +28,10 @@ type appSettings struct {
 	services.Squid
 	services.Techmail
 	services.Samba
+	services.Nfs
+	backup.Backup
+	notify.Notify
+	Modules []services.Module
 }
 
 type response struct {
@@ -33,6 +39,12 @@ type response struct {
 	Description string `json:"description"`
 }
 
+type dataResponse struct {
+	Code        int         `json:"code"`
+	Description string      `json:"description"`
+	Data        interface{} `json:"data"`
+}
+
 var (
 	Settings appSettings
 )
@@ -63,6 +75,10 @@ func ReadConfig(cfg *appSettings) error {
 	services.SmtpSettings = Settings.Smtp
 	services.TechmailSettings = Settings.Techmail
 	services.SquidSettings = Settings.Squid
+	services.NfsSettings = Settings.Nfs
+	backup.BackupSettings = Settings.Backup
+	notify.NotifySettings = Settings.Notify
+	services.ModuleSettings = Settings.Modules
 
 	return nil
 }
@@ -87,12 +103,13 @@ func Run() {
 		dhcp := v0.Group("/dhcp")
 
 		dhcp.Post("/config/download", func(c *routing.Context) error {
-			if err := actionDhcpDownload(c); err != nil {
+			changed, err := actionDhcpDownload(c)
+			if err != nil {
 				sentry.CaptureException(err)
 				return c.Write(response{500, err.Error()})
 			}
 
-			return c.Write(response{200, "Success dhcp download!"})
+			return c.Write(downloadResponse(changed, "Success dhcp download!"))
 		})
 
 		network := dhcp.Group("/network")
@@ -152,12 +169,13 @@ func Run() {
 		smtp := v0.Group("/smtp")
 
 		smtp.Post("/config/download", func(c *routing.Context) error {
-			if err := actionSmtpDownload(c); err != nil {
+			changed, err := actionSmtpDownload(c)
+			if err != nil {
 				sentry.CaptureException(err)
 				return c.Write(response{500, err.Error()})
 			}
 
-			return c.Write(response{200, "Success smtp download!"})
+			return c.Write(downloadResponse(changed, "Success smtp download!"))
 		})
 
 		forward := smtp.Group("/forward")
@@ -170,7 +188,7 @@ func Run() {
 			return c.Write(response{200, "Success create smtp forward!"})
 		})
 		forward.Put("/update", func(c *routing.Context) error {
-			if err := actionSmtpDownload(c); err != nil {
+			if _, err := actionSmtpDownload(c); err != nil {
 				sentry.CaptureException(err)
 				return c.Write(response{500, err.Error()})
 			}
@@ -193,6 +211,167 @@ func Run() {
 
 			return c.Write(response{200, "Success delete smtp forward!"})
 		})
+		forward.Get("/targets", func(c *routing.Context) error {
+			targets, err := actionSmtpForwardTargets(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success smtp forward targets!", targets})
+		})
+		forward.Post("/target/add", func(c *routing.Context) error {
+			if err := actionSmtpForwardAdd(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success add smtp forward target!"})
+		})
+		forward.Post("/target/remove", func(c *routing.Context) error {
+			if err := actionSmtpForwardRemove(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success remove smtp forward target!"})
+		})
+
+		alias := smtp.Group("/alias")
+		alias.Get("/list", func(c *routing.Context) error {
+			aliases, err := actionSmtpAliases()
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success smtp aliases!", aliases})
+		})
+		alias.Post("/target/add", func(c *routing.Context) error {
+			if err := actionSmtpAliasAdd(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success add smtp alias target!"})
+		})
+		alias.Post("/target/remove", func(c *routing.Context) error {
+			if err := actionSmtpAliasRemove(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success remove smtp alias target!"})
+		})
+
+		lookup := smtp.Group("/map")
+		lookup.Get("/list", func(c *routing.Context) error {
+			entries, err := actionSmtpMapEntries(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success smtp map entries!", entries})
+		})
+		lookup.Post("/download", func(c *routing.Context) error {
+			if err := actionSmtpMapDownload(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success smtp map download!"})
+		})
+		lookup.Post("/entry/create", func(c *routing.Context) error {
+			if err := actionSmtpMapCreate(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success create smtp map entry!"})
+		})
+		lookup.Put("/entry/update", func(c *routing.Context) error {
+			if err := actionSmtpMapUpdate(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success update smtp map entry!"})
+		})
+		lookup.Delete("/entry/delete", func(c *routing.Context) error {
+			if err := actionSmtpMapDelete(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success delete smtp map entry!"})
+		})
+
+		smtp.Get("/log/search", func(c *routing.Context) error {
+			events, err := actionSmtpLogSearch(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success smtp log search!", events})
+		})
+
+		queue := smtp.Group("/queue")
+		queue.Get("/list", func(c *routing.Context) error {
+			messages, err := actionSmtpQueue(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success smtp queue!", messages})
+		})
+		queue.Get("/headers", func(c *routing.Context) error {
+			headers, err := actionSmtpQueueHeaders(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success smtp queue message headers!", headers})
+		})
+		queue.Post("/flush", func(c *routing.Context) error {
+			ids, err := actionSmtpQueueFlush(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success flush smtp queue!", ids})
+		})
+		queue.Post("/hold", func(c *routing.Context) error {
+			ids, err := actionSmtpQueueHold(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success hold smtp queue messages!", ids})
+		})
+		queue.Post("/release", func(c *routing.Context) error {
+			ids, err := actionSmtpQueueRelease(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success release smtp queue messages!", ids})
+		})
+		queue.Delete("/delete", func(c *routing.Context) error {
+			ids, err := actionSmtpQueueDelete(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success delete smtp queue messages!", ids})
+		})
 
 		user := smtp.Group("/user")
 		user.Post("/create", func(c *routing.Context) error {
@@ -225,38 +404,71 @@ func Run() {
 		squid := v0.Group("/squid")
 
 		squid.Post("/config/download", func(c *routing.Context) error {
-			if err := actionSquidDownload(c); err != nil {
+			changed, err := actionSquidDownload(c)
+			if err != nil {
 				sentry.CaptureException(err)
 				return c.Write(response{500, err.Error()})
 			}
 
-			return c.Write(response{200, "Success squid download!"})
+			return c.Write(downloadResponse(changed, "Success squid download!"))
 		})
-	}
 
-	if Settings.Techmail.Enabled {
-		tech := v0.Group("/techmail")
+		acl := squid.Group("/acl")
+		acl.Get("/entries", func(c *routing.Context) error {
+			entries, err := actionSquidAclEntries(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
 
-		tech.Post("/config/download", func(c *routing.Context) error {
-			if err := actionTechMailDownload(c); err != nil {
+			return c.Write(dataResponse{200, "Success squid acl entries!", entries})
+		})
+		acl.Post("/entry/add", func(c *routing.Context) error {
+			if err := actionSquidAclAdd(c); err != nil {
 				sentry.CaptureException(err)
 				return c.Write(response{500, err.Error()})
 			}
 
-			return c.Write(response{200, "Success techmail download!"})
+			return c.Write(response{200, "Success add squid acl entries!"})
+		})
+		acl.Delete("/entry/remove", func(c *routing.Context) error {
+			if err := actionSquidAclRemove(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success remove squid acl entries!"})
+		})
+
+		squid.Get("/log/stats", func(c *routing.Context) error {
+			stats, err := actionSquidStats(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success squid access log stats!", stats})
 		})
 	}
 
+	if Settings.Techmail.Enabled {
+		moduleRoutes(v0.Group("/techmail"), services.TechmailModule())
+	}
+
 	if Settings.Samba.Enabled {
+		services.StartQuotaMonitor()
+		services.StartTrashPurge()
+
 		samba := v0.Group("/samba")
 
 		samba.Post("/config/download", func(c *routing.Context) error {
-			if err := actionSambaDownload(c); err != nil {
+			changed, err := actionSambaDownload(c)
+			if err != nil {
 				sentry.CaptureException(err)
 				return c.Write(response{500, err.Error()})
 			}
 
-			return c.Write(response{200, "Success samba download!"})
+			return c.Write(downloadResponse(changed, "Success samba download!"))
 		})
 
 		share := samba.Group("/share")
@@ -268,6 +480,22 @@ func Run() {
 
 			return c.Write(response{200, "Success create samba share!"})
 		})
+		share.Put("/update", func(c *routing.Context) error {
+			if err := actionSambaUpdate(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success update samba share!"})
+		})
+		share.Put("/rename", func(c *routing.Context) error {
+			if err := actionSambaRename(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success rename samba share!"})
+		})
 		share.Put("/quota", func(c *routing.Context) error {
 			if err := actionSambaQuota(c); err != nil {
 				sentry.CaptureException(err)
@@ -276,6 +504,32 @@ func Run() {
 
 			return c.Write(response{200, "Success update samba share quota!"})
 		})
+		share.Get("/usage", func(c *routing.Context) error {
+			usages, err := actionSambaUsage()
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success samba share usage!", usages})
+		})
+		share.Put("/permissions", func(c *routing.Context) error {
+			if err := actionSambaPermissions(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success update samba share permissions!"})
+		})
+		share.Get("/permissions", func(c *routing.Context) error {
+			permissions, err := actionSambaReadPermissions(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success samba share permissions!", permissions})
+		})
 		share.Delete("/delete", func(c *routing.Context) error {
 			if err := actionSambaDelete(c); err != nil {
 				sentry.CaptureException(err)
@@ -292,6 +546,284 @@ func Run() {
 
 			return c.Write(response{200, "Success backup samba server!"})
 		})
+		share.Get("/backup/status", func(c *routing.Context) error {
+			return c.Write(dataResponse{200, "Success samba backup status!", actionSambaBackupStatus()})
+		})
+		share.Get("/snapshots", func(c *routing.Context) error {
+			snapshots, err := actionSambaSnapshots(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success samba share snapshots!", snapshots})
+		})
+		share.Post("/restore", func(c *routing.Context) error {
+			if err := actionSambaRestore(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success restore samba share file!"})
+		})
+		share.Post("/rollback", func(c *routing.Context) error {
+			if err := actionSambaRollback(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success rollback samba share!"})
+		})
+		share.Post("/pull", func(c *routing.Context) error {
+			if err := actionSambaPull(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success pull samba share from backup server!"})
+		})
+
+		user := samba.Group("/user")
+		user.Get("/list", func(c *routing.Context) error {
+			users, err := actionSambaUsers()
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success samba users!", users})
+		})
+		user.Post("/create", func(c *routing.Context) error {
+			if err := actionSambaUserCreate(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success create samba user!"})
+		})
+		user.Put("/disable", func(c *routing.Context) error {
+			if err := actionSambaUserDisable(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success disable samba user!"})
+		})
+		user.Put("/password", func(c *routing.Context) error {
+			if err := actionSambaUserPassword(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success reset samba user password!"})
+		})
+		user.Delete("/delete", func(c *routing.Context) error {
+			if err := actionSambaUserDelete(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success delete samba user!"})
+		})
+
+		group := samba.Group("/group")
+		group.Get("/list", func(c *routing.Context) error {
+			groups, err := actionSambaGroups()
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success samba groups!", groups})
+		})
+		group.Post("/create", func(c *routing.Context) error {
+			if err := actionSambaGroupCreate(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success create samba group!"})
+		})
+		group.Delete("/delete", func(c *routing.Context) error {
+			if err := actionSambaGroupDelete(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success delete samba group!"})
+		})
+		group.Put("/member/add", func(c *routing.Context) error {
+			if err := actionSambaGroupMemberAdd(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success add samba group member!"})
+		})
+		group.Put("/member/remove", func(c *routing.Context) error {
+			if err := actionSambaGroupMemberRemove(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success remove samba group member!"})
+		})
+
+		session := samba.Group("/session")
+		session.Get("/list", func(c *routing.Context) error {
+			sessions, err := actionSambaSessions(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success samba sessions!", sessions})
+		})
+		session.Get("/connections", func(c *routing.Context) error {
+			connections, err := actionSambaConnections(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success samba share connections!", connections})
+		})
+		session.Get("/locks", func(c *routing.Context) error {
+			locks, err := actionSambaLocks(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success samba locked files!", locks})
+		})
+		session.Post("/disconnect", func(c *routing.Context) error {
+			if err := actionSambaDisconnect(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success disconnect samba session!"})
+		})
+		session.Post("/close-share", func(c *routing.Context) error {
+			if err := actionSambaCloseShare(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success close samba share!"})
+		})
+
+		trash := samba.Group("/trash")
+		trash.Get("/list", func(c *routing.Context) error {
+			shares, err := actionSambaTrash()
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success samba trash!", shares})
+		})
+		trash.Post("/undelete", func(c *routing.Context) error {
+			if err := actionSambaUndelete(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success undelete samba share!"})
+		})
+		trash.Post("/purge", func(c *routing.Context) error {
+			if err := actionSambaPurge(); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success purge samba trash!"})
+		})
+
+		dataset := samba.Group("/dataset")
+		dataset.Get("/usage", func(c *routing.Context) error {
+			usage, err := actionDatasetUsage(c)
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success dataset usage!", usage})
+		})
+		dataset.Put("/properties", func(c *routing.Context) error {
+			if err := actionDatasetProperties(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success update dataset properties!"})
+		})
+		dataset.Put("/rename", func(c *routing.Context) error {
+			if err := actionDatasetRename(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success rename dataset!"})
+		})
+	}
+
+	if Settings.Nfs.Enabled {
+		nfs := v0.Group("/nfs")
+
+		nfs.Post("/config/download", func(c *routing.Context) error {
+			if err := actionNfsDownload(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success nfs download!"})
+		})
+
+		export := nfs.Group("/export")
+		export.Get("/list", func(c *routing.Context) error {
+			exports, err := actionNfsExports()
+			if err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(dataResponse{200, "Success nfs exports!", exports})
+		})
+		export.Post("/create", func(c *routing.Context) error {
+			if err := actionNfsCreate(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success create nfs export!"})
+		})
+		export.Put("/update", func(c *routing.Context) error {
+			if err := actionNfsUpdate(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success update nfs export!"})
+		})
+		export.Delete("/delete", func(c *routing.Context) error {
+			if err := actionNfsDelete(c); err != nil {
+				sentry.CaptureException(err)
+				return c.Write(response{500, err.Error()})
+			}
+
+			return c.Write(response{200, "Success delete nfs export!"})
+		})
+	}
+
+	for _, module := range Settings.Modules {
+		if err := module.Check(); err != nil {
+			sentry.CaptureException(err)
+			log.Printf("Skip module: %v", err)
+			continue
+		}
+		moduleRoutes(v0.Group("/"+module.Name), module)
 	}
 
 	// serve index file
@@ -304,3 +836,52 @@ func Run() {
 	http.Handle("/", router)
 	_ = http.ListenAndServe(":"+Settings.Port, nil)
 }
+
+// moduleRoutes registers the standard endpoints of a managed-file module
+func moduleRoutes(group *routing.RouteGroup, module services.Module) {
+	group.Post("/config/download", func(c *routing.Context) error {
+		if err := actionModuleDownload(c, module); err != nil {
+			sentry.CaptureException(err)
+			return c.Write(response{500, err.Error()})
+		}
+
+		return c.Write(response{200, "Success " + module.Name + " download!"})
+	})
+
+	files := group.Group("/file")
+	files.Get("/read", func(c *routing.Context) error {
+		content, err := actionModuleRead(c, module)
+		if err != nil {
+			sentry.CaptureException(err)
+			return c.Write(response{500, err.Error()})
+		}
+
+		return c.Write(dataResponse{200, "Success " + module.Name + " file!", content})
+	})
+	files.Post("/diff", func(c *routing.Context) error {
+		diff, err := actionModuleDiff(c, module)
+		if err != nil {
+			sentry.CaptureException(err)
+			return c.Write(response{500, err.Error()})
+		}
+
+		return c.Write(dataResponse{200, "Success " + module.Name + " file diff!", diff})
+	})
+	files.Post("/rollback", func(c *routing.Context) error {
+		if err := actionModuleRollback(c, module); err != nil {
+			sentry.CaptureException(err)
+			return c.Write(response{500, err.Error()})
+		}
+
+		return c.Write(response{200, "Success " + module.Name + " file rollback!"})
+	})
+}
+
+// downloadResponse reports whether the downloaded config was applied or left as it was because prod is identical
+func downloadResponse(changed bool, description string) dataResponse {
+	if changed {
+		return dataResponse{200, description, "applied"}
+	}
+
+	return dataResponse{200, description, "unchanged"}
+}
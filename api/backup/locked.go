@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"agent/api/notify"
+	"agent/api/samba"
+	"errors"
+	"github.com/getsentry/sentry-go"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	LockedPolicyDefer  = "defer"
+	LockedPolicyNotify = "notify"
+	LockedPolicyClose  = "close"
+
+	LockedActionDeferred = "deferred"
+	LockedActionNotified = "notified"
+	LockedActionClosed   = "closed"
+)
+
+type LockedReport struct {
+	Snapshot  string       `json:"snapshot"`
+	Policy    string       `json:"policy"`
+	Action    string       `json:"action"`
+	Destroyed bool         `json:"destroyed"`
+	Files     []samba.Lock `json:"files"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// destroySnapshot destroys an expired snapshot, files held open in it are handled by the locked files policy
+func (s *snapshot) destroySnapshot(name string, date string) {
+	cli := exec.Command("/sbin/zfs", "destroy", "-fr", name)
+	output, err := cli.CombinedOutput()
+	if err == nil {
+		return
+	}
+	destroyErr := errors.New(err.Error() + ": " + string(output) + " - Error rotate snapshot: " + name)
+
+	locks, err := s.snapshotLocks(date)
+	if err != nil {
+		sentry.CaptureException(errors.New(err.Error() + " - Error get locked files: " + name))
+	}
+	if len(locks) == 0 {
+		sentry.CaptureException(destroyErr)
+		return
+	}
+
+	report := LockedReport{Snapshot: name, Policy: lockedPolicy(), Files: locks}
+	switch report.Policy {
+	case LockedPolicyClose:
+		report.Action = LockedActionClosed
+		for _, pid := range lockedPids(locks) {
+			if err = samba.CloseShare(pid, s.Name); err != nil {
+				report.Error = err.Error()
+				sentry.CaptureException(errors.New(err.Error() + " - Error close share " + s.Name + " for pid " + pid))
+			}
+		}
+
+		cli = exec.Command("/sbin/zfs", "destroy", "-fr", name)
+		output, err = cli.CombinedOutput()
+		if err != nil {
+			message := errors.New(err.Error() + ": " + string(output) + " - Error rotate snapshot: " + name)
+			report.Error = message.Error()
+			sentry.CaptureException(message)
+		} else {
+			report.Destroyed = true
+		}
+	case LockedPolicyNotify:
+		report.Action = LockedActionNotified
+		err = notify.Send("Snapshot rotation deferred: "+name, "Files are held open: "+strings.Join(lockedFileNames(locks), ", "))
+		if err != nil {
+			report.Error = err.Error()
+			sentry.CaptureException(err)
+		}
+	default:
+		report.Action = LockedActionDeferred
+	}
+
+	s.report.Locked = append(s.report.Locked, report)
+}
+
+// snapshotLocks returns the files held open inside the snapshot, either through .zfs or the ___backups___ link
+func (s *snapshot) snapshotLocks(date string) ([]samba.Lock, error) {
+	status, err := samba.ReadStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := []string{
+		filepath.Join(s.Path, ".zfs/snapshot", date),
+		filepath.Join(s.Path, "___backups___", date),
+	}
+
+	var locks []samba.Lock
+	for _, lock := range status.Locks {
+		path := filepath.Join(lock.SharePath, lock.Name)
+		for _, prefix := range prefixes {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				locks = append(locks, lock)
+				break
+			}
+		}
+	}
+
+	return locks, nil
+}
+
+func lockedPolicy() string {
+	switch BackupSettings.LockedFiles.Policy {
+	case LockedPolicyNotify, LockedPolicyClose:
+		return BackupSettings.LockedFiles.Policy
+	}
+
+	return LockedPolicyDefer
+}
+
+func lockedPids(locks []samba.Lock) []string {
+	var pids []string
+	seen := make(map[string]bool)
+	for _, lock := range locks {
+		if !seen[lock.Pid] {
+			seen[lock.Pid] = true
+			pids = append(pids, lock.Pid)
+		}
+	}
+
+	return pids
+}
+
+func lockedFileNames(locks []samba.Lock) []string {
+	var names []string
+	for _, lock := range locks {
+		names = append(names, lock.Name)
+	}
+
+	return names
+}
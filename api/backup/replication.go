@@ -0,0 +1,201 @@
+package backup
+
+import (
+	"agent/api/zfs"
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type Backup struct {
+	Replication struct {
+		RateLimit  int64 `yaml:"rate_limit"`
+		Compressed bool
+		Raw        bool
+		Windows    []string
+	}
+	Transport struct {
+		Type       string
+		User       string
+		Port       string
+		Key        string
+		KnownHosts string `yaml:"known_hosts"`
+		Directory  string
+	}
+	LockedFiles struct {
+		Policy string
+	} `yaml:"locked_files"`
+}
+
+var BackupSettings Backup
+
+// sendFlags returns the zfs send options of the share, a share can only enable what is disabled globally
+func (s *snapshot) sendFlags() []string {
+	var flags []string
+	if BackupSettings.Replication.Compressed || s.IsCompressed == CompressedEnable {
+		flags = append(flags, "-c")
+	}
+	if BackupSettings.Replication.Raw || s.IsRaw == RawEnable {
+		flags = append(flags, "-w")
+	}
+
+	return flags
+}
+
+func (s *snapshot) rateLimit() int64 {
+	if s.RateLimit > 0 {
+		return s.RateLimit
+	}
+
+	return BackupSettings.Replication.RateLimit
+}
+
+// windowCheckInterval is how often a running send checks that the replication window is still open
+const windowCheckInterval = time.Minute
+
+// errWindowClosed interrupts a send when its window closes, the receive keeps its resume token for the next run
+var errWindowClosed = errors.New("Replication window closed, the send is resumed on the next run")
+
+func (s *snapshot) replicationWindows() []string {
+	if len(s.Windows) > 0 {
+		return s.Windows
+	}
+
+	return BackupSettings.Replication.Windows
+}
+
+func (s *snapshot) isReplicationAllowed(t time.Time) (bool, error) {
+	return inWindows(s.replicationWindows(), t)
+}
+
+// send streams zfs send on this host into the transport of the backup server
+func (s *snapshot) send(sendArgs []string, snapshot zfs.Snapshot, force bool) error {
+	var sendStderr bytes.Buffer
+
+	sender := exec.Command("/sbin/zfs", append([]string{"send"}, sendArgs...)...)
+	sender.Stderr = &sendStderr
+
+	stream, err := sender.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = sender.Start(); err != nil {
+		return err
+	}
+
+	var reader io.Reader = stream
+	if limit := s.rateLimit(); limit > 0 {
+		reader = newRateLimitedReader(reader, limit)
+	}
+	var window *windowReader
+	if windows := s.replicationWindows(); len(windows) > 0 {
+		window = &windowReader{reader: reader, windows: windows, checked: time.Now()}
+		reader = window
+	}
+
+	recvErr := s.transport.Receive(s.remoteFs(), snapshot, force, reader)
+	if recvErr != nil {
+		_ = sender.Process.Kill()
+	}
+
+	sendErr := sender.Wait()
+	if window != nil && window.closed {
+		return errWindowClosed
+	}
+	if recvErr != nil {
+		if sendStderr.Len() > 0 {
+			return errors.New(recvErr.Error() + " - " + sendStderr.String())
+		}
+		return recvErr
+	}
+	if sendErr != nil {
+		return errors.New(sendErr.Error() + ": " + sendStderr.String())
+	}
+
+	return nil
+}
+
+type rateLimitedReader struct {
+	reader  io.Reader
+	limit   int64
+	started time.Time
+	read    int64
+}
+
+func newRateLimitedReader(reader io.Reader, limit int64) *rateLimitedReader {
+	return &rateLimitedReader{reader: reader, limit: limit, started: time.Now()}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.limit {
+		p = p[:r.limit]
+	}
+
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+
+	expected := time.Duration(float64(r.read) / float64(r.limit) * float64(time.Second))
+	if wait := expected - time.Since(r.started); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return n, err
+}
+
+// windowReader ends the stream once the replication window closes
+type windowReader struct {
+	reader  io.Reader
+	windows []string
+	checked time.Time
+	closed  bool
+}
+
+func (r *windowReader) Read(p []byte) (int, error) {
+	if t := time.Now(); t.Sub(r.checked) >= windowCheckInterval {
+		r.checked = t
+		allowed, err := inWindows(r.windows, t)
+		r.closed = err == nil && !allowed
+	}
+	if r.closed {
+		return 0, errWindowClosed
+	}
+
+	return r.reader.Read(p)
+}
+
+// inWindows reports whether t falls into one of the "15:04-15:04" windows, a window may wrap past midnight
+func inWindows(windows []string, t time.Time) (bool, error) {
+	if len(windows) == 0 {
+		return true, nil
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range windows {
+		bounds := strings.Split(window, "-")
+		if len(bounds) != 2 {
+			return false, errors.New("Invalid replication window: " + window)
+		}
+		from, err := time.Parse("15:04", strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return false, errors.New("Invalid replication window: " + window)
+		}
+		to, err := time.Parse("15:04", strings.TrimSpace(bounds[1]))
+		if err != nil {
+			return false, errors.New("Invalid replication window: " + window)
+		}
+
+		start := from.Hour()*60 + from.Minute()
+		end := to.Hour()*60 + to.Minute()
+		if start <= end && minute >= start && minute < end {
+			return true, nil
+		}
+		if start > end && (minute >= start || minute < end) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
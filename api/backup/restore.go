@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"agent/api/zfs"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	RestoreDir         = "___restore___"
+	DefaultRestoreMode = "0770"
+)
+
+type Restore struct {
+	Name             string `json:"name" form:"name"`
+	Path             string `json:"path" form:"path"`
+	ZfsPath          string `json:"zfs_path" form:"zfs_path"`
+	BackupServer     string `json:"backup_server" form:"backup_server"`
+	BackupServerPool string `json:"backup_server_pool" form:"backup_server_pool"`
+	Snapshot         string `json:"snapshot"`
+	File             string `json:"file"`
+	Target           string `json:"target"`
+}
+
+type ShareSnapshots struct {
+	Local  []zfs.Snapshot `json:"local"`
+	Remote []zfs.Snapshot `json:"remote"`
+}
+
+func (r *Restore) Snapshots() (ShareSnapshots, error) {
+	var snapshots ShareSnapshots
+
+	var err error
+	snapshots.Local, err = zfs.Local.Snapshots(r.ZfsPath)
+	if err != nil {
+		return snapshots, errors.New(err.Error() + " - Error list snapshots: " + r.ZfsPath)
+	}
+
+	if r.BackupServer == "" || r.BackupServerPool == "" {
+		return snapshots, nil
+	}
+
+	transport, err := Open(r.BackupServer)
+	if err != nil {
+		return snapshots, err
+	}
+	defer transport.Close()
+
+	snapshots.Remote, err = transport.Snapshots(r.remoteFs())
+	if err != nil {
+		return snapshots, err
+	}
+
+	return snapshots, nil
+}
+
+// RestoreFile copies a file or directory from the snapshot into ___restore___/<snapshot> of the share,
+// the directories created on the way get the mode of the shares and the owner of the share root
+func (r *Restore) RestoreFile(mode string) error {
+	if err := r.validateSnapshot(); err != nil {
+		return err
+	}
+
+	file := filepath.Clean("/" + r.File)
+	if file == "/" {
+		return errors.New("File to restore is empty")
+	}
+
+	source := filepath.Join(r.Path, ".zfs/snapshot", r.Snapshot, file)
+	if _, err := os.Stat(source); err != nil {
+		return err
+	}
+
+	destination := filepath.Join(r.Path, RestoreDir, r.Snapshot, file)
+	if err := mkdirShare(r.Path, filepath.Dir(destination), mode); err != nil {
+		return err
+	}
+
+	cli := exec.Command("/usr/bin/cp", "-a", source, destination)
+	output, err := cli.CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+// Rollback reverts the share dataset to the snapshot, later snapshots are destroyed
+func (r *Restore) Rollback() error {
+	if err := r.validateSnapshot(); err != nil {
+		return err
+	}
+
+	cli := exec.Command("/sbin/zfs", "rollback", "-r", r.ZfsPath+"@"+r.Snapshot)
+	output, err := cli.CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+// Pull receives the snapshot from the backup server into the target dataset, which must not exist yet
+func (r *Restore) Pull() error {
+	if err := r.validateSnapshot(); err != nil {
+		return err
+	}
+
+	target := r.Target
+	if target == "" {
+		target = r.ZfsPath + "_restore"
+	}
+
+	transport, err := Open(r.BackupServer)
+	if err != nil {
+		return err
+	}
+	defer transport.Close()
+
+	stream, err := transport.Send(r.remoteFs() + "@" + r.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	cli := exec.Command("/sbin/zfs", "recv", target)
+	cli.Stdin = stream
+	output, recvErr := cli.CombinedOutput()
+	sendErr := stream.Close()
+	if recvErr != nil {
+		return errors.New(recvErr.Error() + ": " + string(output) + " - Error receive snapshot from remote server")
+	}
+	if sendErr != nil {
+		return errors.New(sendErr.Error() + " - Error send snapshot from remote server")
+	}
+
+	return nil
+}
+
+// mkdirShare creates the missing directories from the share root down to dir with the mode and the owner of the root
+func mkdirShare(root string, dir string, mode string) error {
+	if mode == "" {
+		mode = DefaultRestoreMode
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return errors.New("Invalid mode: " + mode)
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("Unknown owner of share: " + root)
+	}
+
+	relative, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	path := root
+	for _, name := range strings.Split(relative, string(filepath.Separator)) {
+		path = filepath.Join(path, name)
+		if err = os.Mkdir(path, 0700); err != nil {
+			if os.IsExist(err) {
+				continue
+			}
+			return err
+		}
+		if err = os.Chown(path, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+		if err = os.Chmod(path, os.FileMode(perm)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Restore) remoteFs() string {
+	return r.BackupServerPool + "/" + r.Name
+}
+
+func (r *Restore) validateSnapshot() error {
+	if r.Snapshot == "" || r.Snapshot == "." || r.Snapshot == ".." || strings.ContainsAny(r.Snapshot, "/@") {
+		return errors.New("Invalid snapshot name: " + r.Snapshot)
+	}
+
+	return nil
+}
@@ -1,22 +1,26 @@
 package backup
 
 import (
+	"agent/api/zfs"
 	"errors"
-	"fmt"
 	"github.com/getsentry/sentry-go"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
-	RemoteBackupDisable   = 1
-	RotatePeriodDay       = "d"
-	RotatePeriodDayCount  = -1
-	RotatePeriodWeek      = "w"
-	RotatePeriodWeekCount = -7
-	RotateWeekDay         = 1
+	RemoteBackupDisable    = 1
+	FullSendFallbackEnable = 1
+	CompressedEnable       = 1
+	RawEnable              = 1
+	RotatePeriodDay        = "d"
+	RotatePeriodDayCount   = -1
+	RotatePeriodWeek       = "w"
+	RotatePeriodWeekCount  = -7
+	RotateWeekDay          = 1
 )
 
 type SnapshotMap struct {
@@ -24,19 +28,25 @@ type SnapshotMap struct {
 }
 
 type snapshot struct {
-	Name             string `json:"name"`
-	Path             string `json:"path"`
-	ZfsPath          string `json:"zfs_path"`
-	BackupServer     string `json:"backup_server"`
-	BackupServerPool string `json:"backup_server_pool"`
-	RotationPeriod   int    `json:"rotation_period"`
-	RotationType     string `json:"rotation_type"`
-	IsRemoteBackup   int    `json:"is_remote_backup"`
+	Name               string   `json:"name"`
+	Path               string   `json:"path"`
+	ZfsPath            string   `json:"zfs_path"`
+	BackupServer       string   `json:"backup_server"`
+	BackupServerPool   string   `json:"backup_server_pool"`
+	RotationPeriod     int      `json:"rotation_period"`
+	RotationType       string   `json:"rotation_type"`
+	IsRemoteBackup     int      `json:"is_remote_backup"`
+	IsFullSendFallback int      `json:"is_full_send_fallback"`
+	IsCompressed       int      `json:"is_compressed"`
+	IsRaw              int      `json:"is_raw"`
+	RateLimit          int64    `json:"rate_limit"`
+	Windows            []string `json:"windows"`
+	report             ShareStatus
+	transport          Transport
 }
 
 var (
 	now             time.Time
-	previous        = 0
 	rotation        = 0
 	currentSnapshot string
 )
@@ -45,8 +55,15 @@ func (s *SnapshotMap) Backup() error {
 	now = time.Now()
 	shares := s.Data
 
+	beginJob(now)
+	defer finishJob()
+
+	connections := make(transports)
+	defer connections.close()
+
 	for _, share := range shares {
 		if share.isFsNotExist() {
+			addReport(ShareStatus{Name: share.Name, Error: "File system doesn't exists"})
 			continue
 		}
 
@@ -56,26 +73,45 @@ func (s *SnapshotMap) Backup() error {
 
 		share.init()
 
+		if share.IsRemoteBackup != RemoteBackupDisable {
+			transport, err := connections.open(share.BackupServer)
+			if err != nil {
+				sentry.CaptureException(err)
+				share.report.Error = err.Error()
+			}
+			share.transport = transport
+		}
+
 		share.createBackupLink()
 
 		_ = share.rotate()
 
 		err := share.create()
 		if err != nil {
+			share.report.Error = err.Error()
+			addReport(share.report)
 			continue
 		}
 
-		_ = share.clone()
+		err = share.clone()
+		if err != nil {
+			share.report.Error = err.Error()
+		}
+		addReport(share.report)
 	}
 
 	return nil
 }
 
 func (s *snapshot) isFsNotExist() bool {
-	cli := exec.Command("/sbin/zfs", "list", s.ZfsPath)
-	output, err := cli.CombinedOutput()
+	exists, err := zfs.Local.Exists(s.ZfsPath)
 	if err != nil {
-		message := errors.New(err.Error() + ": " + string(output) + " - File system doesn't exists: " + s.Name)
+		message := errors.New(err.Error() + " - Error check file system: " + s.Name)
+		sentry.CaptureException(message)
+		return true
+	}
+	if !exists {
+		message := errors.New("File system doesn't exists: " + s.Name)
 		sentry.CaptureException(message)
 		return true
 	}
@@ -85,13 +121,13 @@ func (s *snapshot) isFsNotExist() bool {
 
 func (s *snapshot) init() {
 	currentSnapshot = s.ZfsPath + "@" + now.Format("2006-01-02")
+	s.report = ShareStatus{Name: s.Name, Snapshot: currentSnapshot}
 
+	rotation = 0
 	switch s.RotationType {
 	case RotatePeriodDay:
-		previous = RotatePeriodDayCount
 		rotation = RotatePeriodDayCount * s.RotationPeriod
 	case RotatePeriodWeek:
-		previous = RotatePeriodWeekCount
 		rotation = RotatePeriodWeekCount * s.RotationPeriod
 	}
 }
@@ -109,69 +145,86 @@ func (s *snapshot) createBackupLink() {
 	}
 }
 
+// rotate destroys the snapshots of the agent older than the rotation period, the newest snapshot shared with
+// the backup server is kept as the base of the next increment
 func (s *snapshot) rotate() error {
+	if s.RotationPeriod <= 0 || rotation >= 0 {
+		return nil
+	}
 	rotationDate := now.AddDate(0, 0, rotation)
-	rotationSnapshot := s.ZfsPath + "@" + rotationDate.Format("2006-01-02")
-	if s.isSnapshotExist(rotationSnapshot) {
-		cli := exec.Command("/sbin/zfs", "destroy", "-fr", rotationSnapshot)
-		_, err := cli.CombinedOutput()
-		if err != nil {
-			err = s.killLockedProcesses(rotationDate)
-			if err == nil {
-				cli = exec.Command("/sbin/zfs", "destroy", "-fr", rotationSnapshot)
-				output, err := cli.CombinedOutput()
-				if err != nil {
-					message := errors.New(err.Error() + ": " + string(output) + " - Error rotate snapshot: " + rotationSnapshot)
-					sentry.CaptureException(message)
-				}
-			}
-		}
+
+	localSnapshots, err := s.localSnapshots()
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
 	}
 
-	if s.IsRemoteBackup == RemoteBackupDisable {
-		return nil
+	remote := s.IsRemoteBackup != RemoteBackupDisable
+	if remote && s.transport == nil {
+		message := errors.New("Backup server is unavailable, rotation skipped: " + s.Name)
+		sentry.CaptureException(message)
+		return message
 	}
 
-	rotationRemoteSnapshot := s.BackupServerPool + "/" + s.Name + "@" + rotationDate.Format("2006-01-02")
-	if s.isRemoteSnapshotExist(rotationRemoteSnapshot) {
-		cli := exec.Command("ssh", s.BackupServer, "zfs", "destroy", "-fr", rotationRemoteSnapshot)
-		output, err := cli.CombinedOutput()
-		if err != nil {
-			message := errors.New(err.Error() + ": " + string(output) + " - Error rotate remote snapshot: " + rotationRemoteSnapshot)
+	remoteFsExist := false
+	commonSnapshot := ""
+	if remote {
+		if remoteFsExist, err = s.isRemoteFsExist(); err != nil {
+			message := errors.New(err.Error() + " - Error check remote file system, rotation skipped: " + s.Name)
+			sentry.CaptureException(message)
+			return message
+		}
+	}
+	if remoteFsExist {
+		if commonSnapshot, err = s.latestCommonSnapshot(localSnapshots); err != nil {
+			message := errors.New(err.Error() + " - Error find common snapshot, rotation skipped: " + s.Name)
 			sentry.CaptureException(message)
+			return message
 		}
 	}
 
-	return nil
-}
+	for _, local := range localSnapshots {
+		date, ok := s.snapshotDate(local.Name)
+		if !ok || local.Name == commonSnapshot || date.After(rotationDate) {
+			continue
+		}
 
-func (s *snapshot) killLockedProcesses(rotationDate time.Time) error {
-	command := fmt.Sprintf("/usr/bin/smbstatus | grep %s | grep %s | awk '{print $1}'", s.Name, rotationDate.Format("2006-01-02"))
-	cli := exec.Command("/usr/bin/bash", "-c", command)
-	output, err := cli.CombinedOutput()
-	if err != nil {
-		message := errors.New(err.Error() + ": " + string(output) + " - Error get locked processes!")
-		sentry.CaptureException(message)
-		return message
+		s.destroySnapshot(local.Name, date.Format("2006-01-02"))
 	}
 
-	data := strings.Split(string(output), "\n")
-	data = unique(data)
+	if !remoteFsExist {
+		return nil
+	}
 
-	for _, id := range data {
-		command = fmt.Sprintf("/usr/bin/kill -9 %s", id)
-		cli = exec.Command("/usr/bin/bash", "-c", command)
-		output, err = cli.CombinedOutput()
+	rotationRemoteSnapshot := s.remoteFs() + "@" + rotationDate.Format("2006-01-02")
+	if s.ZfsPath+"@"+rotationDate.Format("2006-01-02") == commonSnapshot {
+		return nil
+	}
+	if s.isRemoteSnapshotExist(rotationRemoteSnapshot) {
+		err := s.transport.Destroy(rotationRemoteSnapshot)
 		if err != nil {
-			message := errors.New(err.Error() + ": " + string(output) + " - Error kill locked process id = " + id)
+			message := errors.New(err.Error() + " - Error rotate remote snapshot: " + rotationRemoteSnapshot)
 			sentry.CaptureException(message)
-			return message
 		}
 	}
 
 	return nil
 }
 
+// snapshotDate returns the date of a snapshot the agent created, those are named <dataset>@YYYY-MM-DD
+func (s *snapshot) snapshotDate(name string) (time.Time, bool) {
+	dataset, date, _ := strings.Cut(name, "@")
+	if dataset != s.ZfsPath {
+		return time.Time{}, false
+	}
+	parsed, err := time.ParseInLocation("2006-01-02", date, now.Location())
+	if err != nil || parsed.Format("2006-01-02") != date {
+		return time.Time{}, false
+	}
+
+	return parsed, true
+}
+
 func (s *snapshot) create() error {
 	if s.isSnapshotExist(currentSnapshot) {
 		return nil
@@ -189,7 +242,17 @@ func (s *snapshot) create() error {
 }
 
 func (s *snapshot) clone() error {
-	if s.IsRemoteBackup == RemoteBackupDisable {
+	if s.IsRemoteBackup == RemoteBackupDisable || s.transport == nil {
+		return nil
+	}
+
+	allowed, err := s.isReplicationAllowed(time.Now())
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+	if !allowed {
+		s.report.Mode = ModeSkipped
 		return nil
 	}
 
@@ -197,45 +260,129 @@ func (s *snapshot) clone() error {
 }
 
 func (s *snapshot) sendIncrement() error {
-	if _, err := os.Stat(s.Path + "/.zfs/snapshot/" + currentSnapshot); os.IsNotExist(err) {
+	if !s.isSnapshotExist(currentSnapshot) {
 		return nil
 	}
 
-	if s.isRemoteFsExist() {
-		previousDate := now.AddDate(0, 0, previous)
-		previousSnapshot := s.ZfsPath + "@" + previousDate.Format("2006-01-02")
+	remoteFsExist, err := s.isRemoteFsExist()
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+	if !remoteFsExist {
+		return s.sendSnapshot()
+	}
 
-		if _, err := os.Stat(s.Path + "/.zfs/snapshot/" + previousDate.Format("2006-01-02")); !os.IsNotExist(err) {
-			command := fmt.Sprintf("zfs send -i %s %s | ssh %s zfs recv -F %s/%s", previousSnapshot, currentSnapshot, s.BackupServer, s.BackupServerPool, s.Name)
-			cli := exec.Command("/usr/bin/bash", "-c", command)
-			_, err := cli.CombinedOutput()
-			if err != nil {
-				return s.sendSnapshot()
-			}
+	if err = s.resume(); err != nil {
+		return err
+	}
+
+	localSnapshots, err := s.localSnapshots()
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	commonSnapshot, err := s.latestCommonSnapshot(localSnapshots)
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	if commonSnapshot == "" {
+		remoteFsEmpty, err := s.isRemoteFsEmpty()
+		if err != nil {
+			sentry.CaptureException(err)
+			return err
+		}
+		// a backup dataset without snapshots, left by a failed first send, has no history to lose
+		if !remoteFsEmpty && s.IsFullSendFallback != FullSendFallbackEnable {
+			message := errors.New("No common snapshot with remote server, full send is disabled: " + s.Name)
+			sentry.CaptureException(message)
+			return message
+		}
+
+		return s.sendSnapshot()
+	}
+
+	if commonSnapshot == currentSnapshot {
+		return nil
+	}
+
+	s.report.Mode = ModeIncremental
+	sendArgs := append(s.sendFlags(), "-I", commonSnapshot, currentSnapshot)
+	err = s.send(sendArgs, findSnapshot(localSnapshots, currentSnapshot), true)
+	if err == errWindowClosed {
+		return err
+	}
+	if err != nil {
+		message := errors.New(err.Error() + " - Error send increment to remote server from " + commonSnapshot)
+		sentry.CaptureException(message)
+		return message
+	}
+
+	return nil
+}
+
+func (s *snapshot) localSnapshots() ([]zfs.Snapshot, error) {
+	snapshots, err := zfs.Local.Snapshots(s.ZfsPath)
+	if err != nil {
+		return nil, errors.New(err.Error() + " - Error list snapshots: " + s.Name)
+	}
+
+	return snapshots, nil
+}
 
-			return nil
+// latestCommonSnapshot returns the newest local snapshot whose guid is also present on the backup server
+func (s *snapshot) latestCommonSnapshot(localSnapshots []zfs.Snapshot) (string, error) {
+	remoteSnapshots, err := s.transport.Snapshots(s.remoteFs())
+	if err != nil {
+		return "", err
+	}
+	remoteGuids := make(map[string]bool)
+	for _, remote := range remoteSnapshots {
+		remoteGuids[remote.Guid] = true
+	}
+
+	for i := len(localSnapshots) - 1; i >= 0; i-- {
+		if remoteGuids[localSnapshots[i].Guid] {
+			return localSnapshots[i].Name, nil
 		}
 	}
 
-	return s.sendSnapshot()
+	return "", nil
 }
 
 func (s *snapshot) sendSnapshot() error {
-	if s.isRemoteFsExist() {
+	remoteFsExist, err := s.isRemoteFsExist()
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+	if remoteFsExist {
 		s.destroyRemoteFs()
 	}
 
-	err := s.createRemoteFs()
+	err = s.createRemoteFs()
 	if err != nil {
 		sentry.CaptureException(err)
 		return err
 	}
 
-	command := fmt.Sprintf("zfs send %s | ssh %s zfs recv -F %s/%s", currentSnapshot, s.BackupServer, s.BackupServerPool, s.Name)
-	cli := exec.Command("/usr/bin/bash", "-c", command)
-	output, err := cli.CombinedOutput()
+	localSnapshots, err := s.localSnapshots()
+	if err != nil {
+		sentry.CaptureException(err)
+		return err
+	}
+
+	s.report.Mode = ModeFull
+	sendArgs := append(s.sendFlags(), currentSnapshot)
+	err = s.send(sendArgs, findSnapshot(localSnapshots, currentSnapshot), true)
+	if err == errWindowClosed {
+		return err
+	}
 	if err != nil {
-		message := errors.New(err.Error() + ": " + string(output) + " - Error send snapshot to remote server")
+		message := errors.New(err.Error() + " - Error send snapshot to remote server")
 		sentry.CaptureException(message)
 		return message
 	}
@@ -243,50 +390,109 @@ func (s *snapshot) sendSnapshot() error {
 	return nil
 }
 
+// resume finishes an interrupted receive on the backup server, a stale token is aborted so the regular send can run,
+// a resume cut by the end of the window keeps its token and the error is returned
+func (s *snapshot) resume() error {
+	token := s.transport.ResumeToken(s.remoteFs())
+	if token == "" {
+		return nil
+	}
+
+	bytesSaved := resumeTokenBytes(token)
+
+	err := s.send([]string{"-t", token}, zfs.Snapshot{}, false)
+	if err == errWindowClosed {
+		return err
+	}
+	if err != nil {
+		message := errors.New(err.Error() + " - Error resume send to remote server: " + s.Name)
+		sentry.CaptureException(message)
+
+		if err = s.transport.AbortReceive(s.remoteFs()); err != nil {
+			sentry.CaptureException(err)
+		}
+		return nil
+	}
+
+	s.report.Resumed = true
+	s.report.BytesSaved = bytesSaved
+
+	return nil
+}
+
+// resumeTokenBytes returns the amount of data the backup server already received before the interruption
+func resumeTokenBytes(token string) int64 {
+	cli := exec.Command("/sbin/zfs", "send", "-nv", "-t", token)
+	output, _ := cli.CombinedOutput()
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "bytes" && fields[1] == "=" {
+			bytes, err := strconv.ParseInt(fields[2], 0, 64)
+			if err == nil {
+				return bytes
+			}
+		}
+	}
+
+	return 0
+}
+
 func (s *snapshot) isSnapshotExist(snapshot string) bool {
-	cli := exec.Command("/sbin/zfs", "list", snapshot)
-	_, err := cli.CombinedOutput()
+	exists, err := zfs.Local.Exists(snapshot)
+	if err != nil {
+		sentry.CaptureException(err)
+	}
+
+	return exists
+}
 
-	return err == nil
+func (s *snapshot) remoteFs() string {
+	return s.BackupServerPool + "/" + s.Name
 }
 
-func (s *snapshot) isRemoteFsExist() bool {
-	cli := exec.Command("ssh", s.BackupServer, "zfs", "list", s.BackupServerPool+"/"+s.Name)
-	_, err := cli.CombinedOutput()
-	return err == nil
+func (s *snapshot) isRemoteFsExist() (bool, error) {
+	return s.transport.Exists(s.remoteFs())
+}
+
+func (s *snapshot) isRemoteFsEmpty() (bool, error) {
+	remoteSnapshots, err := s.transport.Snapshots(s.remoteFs())
+	if err != nil {
+		return false, err
+	}
+
+	return len(remoteSnapshots) == 0, nil
 }
 
 func (s *snapshot) isRemoteSnapshotExist(snapshot string) bool {
-	cli := exec.Command("ssh", s.BackupServer, "zfs", "list", snapshot)
-	_, err := cli.CombinedOutput()
-	return err == nil
+	exists, err := s.transport.Exists(snapshot)
+	if err != nil {
+		sentry.CaptureException(err)
+	}
+
+	return exists
 }
 
 func (s *snapshot) createRemoteFs() error {
-	cli := exec.Command("ssh", s.BackupServer, "zfs", "create", "-o", "compression=on", s.BackupServerPool+"/"+s.Name)
-	output, err := cli.CombinedOutput()
+	err := s.transport.Create(s.remoteFs())
 	if err != nil {
-		message := errors.New(err.Error() + ": " + string(output) + " - Error create remote file system")
-		sentry.CaptureException(message)
-		return message
+		sentry.CaptureException(err)
+		return err
 	}
 
 	return nil
 }
 
 func (s *snapshot) destroyRemoteFs() {
-	cli := exec.Command("ssh", s.BackupServer, "zfs", "destroy", "-fr", s.BackupServerPool+"/"+s.Name)
-	_, _ = cli.CombinedOutput()
+	_ = s.transport.Destroy(s.remoteFs())
 }
 
-func unique(slice []string) []string {
-	keys := make(map[string]bool)
-	var list []string
-	for _, entry := range slice {
-		if _, value := keys[entry]; !value {
-			keys[entry] = true
-			list = append(list, entry)
+func findSnapshot(list []zfs.Snapshot, name string) zfs.Snapshot {
+	for _, snapshot := range list {
+		if snapshot.Name == name {
+			return snapshot
 		}
 	}
-	return list
+
+	return zfs.Snapshot{Name: name}
 }
@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	ModeIncremental = "incremental"
+	ModeFull        = "full"
+	ModeSkipped     = "skipped"
+)
+
+type Status struct {
+	Running  bool          `json:"running"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Shares   []ShareStatus `json:"shares"`
+}
+
+type ShareStatus struct {
+	Name       string         `json:"name"`
+	Snapshot   string         `json:"snapshot"`
+	Mode       string         `json:"mode"`
+	Resumed    bool           `json:"resumed"`
+	BytesSaved int64          `json:"bytes_saved"`
+	Locked     []LockedReport `json:"locked,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+var (
+	jobMutex  sync.Mutex
+	jobStatus Status
+)
+
+func JobStatus() Status {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	status := jobStatus
+	status.Shares = append([]ShareStatus(nil), jobStatus.Shares...)
+
+	return status
+}
+
+func beginJob(started time.Time) {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	jobStatus = Status{Running: true, Started: started}
+}
+
+func finishJob() {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	jobStatus.Running = false
+	jobStatus.Finished = time.Now()
+}
+
+func addReport(report ShareStatus) {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	jobStatus.Shares = append(jobStatus.Shares, report)
+}
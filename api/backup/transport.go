@@ -0,0 +1,423 @@
+package backup
+
+import (
+	"agent/api/command"
+	"agent/api/zfs"
+	"bytes"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	TransportSsh   = "ssh"
+	TransportLocal = "local"
+)
+
+// Transport is the backup side of the replication
+type Transport interface {
+	Exists(name string) (bool, error)
+	Snapshots(dataset string) ([]zfs.Snapshot, error)
+	ResumeToken(dataset string) string
+	AbortReceive(dataset string) error
+	Create(dataset string) error
+	Destroy(name string) error
+	Rename(dataset string, target string) error
+	Receive(dataset string, snapshot zfs.Snapshot, force bool, stream io.Reader) error
+	Send(snapshot string) (io.ReadCloser, error)
+	Close() error
+}
+
+type transports map[string]Transport
+
+// open returns the transport of the backup server, connections are reused across the shares of one run
+func (t transports) open(server string) (Transport, error) {
+	if transport, ok := t[server]; ok {
+		return transport, nil
+	}
+
+	var transport Transport
+	settings := BackupSettings.Transport
+	switch settings.Type {
+	case "", TransportSsh:
+		runner, err := dialSsh(server)
+		if err != nil {
+			return nil, err
+		}
+		transport = &zfsTransport{client: zfs.Client{Runner: runner, Binary: "zfs"}}
+	case TransportLocal:
+		if settings.Directory != "" {
+			transport = &directoryTransport{root: settings.Directory}
+		} else {
+			transport = &zfsTransport{client: zfs.Local}
+		}
+	default:
+		return nil, errors.New("Unknown backup transport: " + settings.Type)
+	}
+
+	t[server] = transport
+
+	return transport, nil
+}
+
+// Open connects to the backup server outside of a backup run, the caller closes the transport
+func Open(server string) (Transport, error) {
+	return make(transports).open(server)
+}
+
+func (t transports) close() {
+	for _, transport := range t {
+		_ = transport.Close()
+	}
+}
+
+// zfsTransport runs zfs on the backup side through a command runner, either over ssh or on this host
+type zfsTransport struct {
+	client zfs.Client
+}
+
+func (t *zfsTransport) Exists(name string) (bool, error) {
+	exists, err := t.client.Exists(name)
+	if err != nil {
+		return false, errors.New(err.Error() + " - Error check remote: " + name)
+	}
+
+	return exists, nil
+}
+
+func (t *zfsTransport) Snapshots(dataset string) ([]zfs.Snapshot, error) {
+	snapshots, err := t.client.Snapshots(dataset)
+	if err != nil {
+		return nil, errors.New(err.Error() + " - Error list remote snapshots: " + dataset)
+	}
+
+	return snapshots, nil
+}
+
+func (t *zfsTransport) ResumeToken(dataset string) string {
+	properties, err := t.client.Get(dataset, "receive_resume_token")
+	if err != nil {
+		return ""
+	}
+
+	token := properties["receive_resume_token"]
+	if token == "-" {
+		return ""
+	}
+
+	return token
+}
+
+func (t *zfsTransport) AbortReceive(dataset string) error {
+	output, err := t.client.Runner.Run(t.client.Binary, "recv", "-A", dataset)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output) + " - Error abort remote receive: " + dataset)
+	}
+
+	return nil
+}
+
+func (t *zfsTransport) Create(dataset string) error {
+	output, err := t.client.Runner.Run(t.client.Binary, "create", "-o", "compression=on", dataset)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output) + " - Error create remote file system")
+	}
+
+	return nil
+}
+
+func (t *zfsTransport) Destroy(name string) error {
+	output, err := t.client.Runner.Run(t.client.Binary, "destroy", "-fr", name)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output) + " - Error destroy remote: " + name)
+	}
+
+	return nil
+}
+
+func (t *zfsTransport) Rename(dataset string, target string) error {
+	output, err := t.client.Runner.Run(t.client.Binary, "rename", "-p", dataset, target)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output) + " - Error rename remote: " + dataset)
+	}
+
+	return nil
+}
+
+func (t *zfsTransport) Receive(dataset string, snapshot zfs.Snapshot, force bool, stream io.Reader) error {
+	args := []string{"recv", "-s"}
+	if force {
+		args = append(args, "-F")
+	}
+	args = append(args, dataset)
+
+	output, err := t.client.Runner.Stream(stream, t.client.Binary, args...)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+func (t *zfsTransport) Send(snapshot string) (io.ReadCloser, error) {
+	return t.client.Runner.Open(t.client.Binary, "send", snapshot)
+}
+
+func (t *zfsTransport) Close() error {
+	if closer, ok := t.client.Runner.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// directoryTransport keeps every received stream as a file named <snapshot>.<guid>.zfs in a directory per dataset
+type directoryTransport struct {
+	root string
+}
+
+func (t *directoryTransport) path(dataset string) string {
+	return filepath.Join(t.root, filepath.FromSlash(dataset))
+}
+
+func (t *directoryTransport) Exists(name string) (bool, error) {
+	dataset, snapshot, isSnapshot := strings.Cut(name, "@")
+	if !isSnapshot {
+		_, err := os.Stat(t.path(dataset))
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(t.path(dataset), snapshot+".*.zfs"))
+	return len(files) > 0, err
+}
+
+func (t *directoryTransport) Snapshots(dataset string) ([]zfs.Snapshot, error) {
+	files, err := os.ReadDir(t.path(dataset))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []zfs.Snapshot
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".zfs")
+		dot := strings.LastIndex(name, ".")
+		if file.IsDir() || name == file.Name() || dot < 0 {
+			continue
+		}
+		list = append(list, zfs.Snapshot{Name: dataset + "@" + name[:dot], Guid: name[dot+1:]})
+	}
+
+	return list, nil
+}
+
+func (t *directoryTransport) ResumeToken(dataset string) string {
+	return ""
+}
+
+func (t *directoryTransport) AbortReceive(dataset string) error {
+	return nil
+}
+
+func (t *directoryTransport) Create(dataset string) error {
+	return os.MkdirAll(t.path(dataset), 0750)
+}
+
+func (t *directoryTransport) Destroy(name string) error {
+	dataset, snapshot, isSnapshot := strings.Cut(name, "@")
+	if !isSnapshot {
+		return os.RemoveAll(t.path(dataset))
+	}
+
+	files, err := filepath.Glob(filepath.Join(t.path(dataset), snapshot+".*.zfs"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *directoryTransport) Rename(dataset string, target string) error {
+	if err := os.MkdirAll(filepath.Dir(t.path(target)), 0750); err != nil {
+		return err
+	}
+
+	return os.Rename(t.path(dataset), t.path(target))
+}
+
+func (t *directoryTransport) Receive(dataset string, snapshot zfs.Snapshot, force bool, stream io.Reader) error {
+	if snapshot.Name == "" {
+		return errors.New("Directory transport can't receive a stream without snapshot: " + dataset)
+	}
+	if err := t.Create(dataset); err != nil {
+		return err
+	}
+
+	_, snapshotName, _ := strings.Cut(snapshot.Name, "@")
+	file := filepath.Join(t.path(dataset), snapshotName+"."+snapshot.Guid+".zfs")
+	temp, err := os.CreateTemp(t.path(dataset), ".recv-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err = io.Copy(temp, stream); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err = temp.Sync(); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), file)
+}
+
+// Send returns the stored stream of the snapshot, it can only be received on its own if it was a full send
+func (t *directoryTransport) Send(snapshot string) (io.ReadCloser, error) {
+	dataset, snapshotName, _ := strings.Cut(snapshot, "@")
+	files, err := filepath.Glob(filepath.Join(t.path(dataset), snapshotName+".*.zfs"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("Snapshot doesn't exists: " + snapshot)
+	}
+
+	return os.Open(files[0])
+}
+
+func (t *directoryTransport) Close() error {
+	return nil
+}
+
+// sshRunner runs commands on the backup server over one native ssh connection
+type sshRunner struct {
+	client *ssh.Client
+}
+
+func dialSsh(server string) (*sshRunner, error) {
+	settings := BackupSettings.Transport
+
+	user := settings.User
+	host := server
+	if at := strings.LastIndex(server, "@"); at >= 0 {
+		user = server[:at]
+		host = server[at+1:]
+	}
+	if user == "" {
+		user = "root"
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		port := settings.Port
+		if port == "" {
+			port = "22"
+		}
+		host = net.JoinHostPort(host, port)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/root"
+	}
+	keyFile := settings.Key
+	if keyFile == "" {
+		keyFile = home + "/.ssh/id_rsa"
+	}
+	knownHostsFile := settings.KnownHosts
+	if knownHostsFile == "" {
+		knownHostsFile = home + "/.ssh/known_hosts"
+	}
+
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, errors.New(err.Error() + " - Error parse ssh key: " + keyFile)
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, errors.New(err.Error() + " - Error connect to backup server: " + server)
+	}
+
+	return &sshRunner{client: client}, nil
+}
+
+func (r *sshRunner) Run(name string, args ...string) ([]byte, error) {
+	return r.Stream(nil, name, args...)
+}
+
+func (r *sshRunner) Stream(stdin io.Reader, name string, args ...string) ([]byte, error) {
+	session, err := r.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	session.Stdin = stdin
+
+	return session.CombinedOutput(shellJoin(name, args))
+}
+
+func (r *sshRunner) Open(name string, args ...string) (io.ReadCloser, error) {
+	var stderr bytes.Buffer
+
+	session, err := r.client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	session.Stderr = &stderr
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	if err = session.Start(shellJoin(name, args)); err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+
+	return &command.Output{Reader: stdout, Stderr: &stderr, Wait: func() error {
+		defer session.Close()
+		return session.Wait()
+	}, Kill: session.Close}, nil
+}
+
+func (r *sshRunner) Close() error {
+	return r.client.Close()
+}
+
+func shellJoin(name string, args []string) string {
+	quoted := []string{name}
+	for _, arg := range args {
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+
+	return strings.Join(quoted, " ")
+}
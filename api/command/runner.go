@@ -0,0 +1,80 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// Runner executes a command and returns its combined output, the same way exec.Cmd.CombinedOutput does
+type Runner interface {
+	Run(name string, args ...string) ([]byte, error)
+	Stream(stdin io.Reader, name string, args ...string) ([]byte, error)
+	// Open starts the command and returns its standard output, Close waits for the command to exit
+	Open(name string, args ...string) (io.ReadCloser, error)
+}
+
+type Local struct{}
+
+func (l Local) Run(name string, args ...string) ([]byte, error) {
+	cli := exec.Command(name, args...)
+	return cli.CombinedOutput()
+}
+
+func (l Local) Stream(stdin io.Reader, name string, args ...string) ([]byte, error) {
+	cli := exec.Command(name, args...)
+	cli.Stdin = stdin
+	return cli.CombinedOutput()
+}
+
+func (l Local) Open(name string, args ...string) (io.ReadCloser, error) {
+	var stderr bytes.Buffer
+
+	cli := exec.Command(name, args...)
+	cli.Stderr = &stderr
+	stdout, err := cli.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cli.Start(); err != nil {
+		return nil, err
+	}
+
+	return &Output{Reader: stdout, Stderr: &stderr, Wait: cli.Wait, Kill: cli.Process.Kill}, nil
+}
+
+// Output is the standard output of a started command, Kill stops a command whose output is not read to the end
+type Output struct {
+	io.Reader
+	Stderr *bytes.Buffer
+	Wait   func() error
+	Kill   func() error
+	eof    bool
+}
+
+func (o *Output) Read(p []byte) (int, error) {
+	n, err := o.Reader.Read(p)
+	if err == io.EOF {
+		o.eof = true
+	}
+
+	return n, err
+}
+
+// Close waits for a command read to the end, otherwise it kills the command instead of draining its output
+func (o *Output) Close() error {
+	if !o.eof {
+		if o.Kill != nil {
+			_ = o.Kill()
+		}
+		_ = o.Wait()
+		return errors.New("Command output was not read to the end: " + o.Stderr.String())
+	}
+
+	if err := o.Wait(); err != nil {
+		return errors.New(err.Error() + ": " + o.Stderr.String())
+	}
+
+	return nil
+}
@@ -2,14 +2,17 @@ package api
 
 import (
 	"agent/api/backup"
+	"agent/api/mail"
+	"agent/api/samba"
 	"agent/api/services"
+	"agent/api/squid"
 	"github.com/go-ozzo/ozzo-routing/v2"
 )
 
-func actionDhcpDownload(c *routing.Context) error {
+func actionDhcpDownload(c *routing.Context) (bool, error) {
 	var dhcp services.DhcpString
 	if err := c.Read(&dhcp); err != nil {
-		return err
+		return false, err
 	}
 
 	return dhcp.Download()
@@ -42,10 +45,10 @@ func actionDhcpDelete(c *routing.Context) error {
 	return dhcp.Delete()
 }
 
-func actionSmtpDownload(c *routing.Context) error {
+func actionSmtpDownload(c *routing.Context) (bool, error) {
 	var smtp services.SmtpString
 	if err := c.Read(&smtp); err != nil {
-		return err
+		return false, err
 	}
 
 	return smtp.SmtpDownload()
@@ -96,28 +99,248 @@ func actionSmtpUserDelete(c *routing.Context) error {
 	return smtp.UserDelete()
 }
 
-func actionTechMailDownload(c *routing.Context) error {
-	var smtp services.SmtpString
-	if err := c.Read(&smtp); err != nil {
+func actionSmtpAliases() ([]*mail.Alias, error) {
+	return services.SmtpAliases()
+}
+
+func actionSmtpAliasAdd(c *routing.Context) error {
+	var alias services.SmtpAlias
+	if err := c.Read(&alias); err != nil {
+		return err
+	}
+
+	return alias.AddTarget()
+}
+
+func actionSmtpAliasRemove(c *routing.Context) error {
+	var alias services.SmtpAlias
+	if err := c.Read(&alias); err != nil {
+		return err
+	}
+
+	return alias.RemoveTarget()
+}
+
+func actionSmtpForwardTargets(c *routing.Context) ([]mail.Target, error) {
+	var forward services.SmtpForward
+	if err := c.Read(&forward); err != nil {
+		return nil, err
+	}
+
+	return forward.Targets()
+}
+
+func actionSmtpForwardAdd(c *routing.Context) error {
+	var forward services.SmtpForward
+	if err := c.Read(&forward); err != nil {
+		return err
+	}
+
+	return forward.AddTarget()
+}
+
+func actionSmtpForwardRemove(c *routing.Context) error {
+	var forward services.SmtpForward
+	if err := c.Read(&forward); err != nil {
+		return err
+	}
+
+	return forward.RemoveTarget()
+}
+
+func actionSmtpMapEntries(c *routing.Context) ([]*mail.Entry, error) {
+	var lookup services.PostfixMap
+	if err := c.Read(&lookup); err != nil {
+		return nil, err
+	}
+
+	return lookup.Entries()
+}
+
+func actionSmtpMapDownload(c *routing.Context) error {
+	var lookup services.PostfixMap
+	if err := c.Read(&lookup); err != nil {
+		return err
+	}
+
+	return lookup.Download()
+}
+
+func actionSmtpMapCreate(c *routing.Context) error {
+	var lookup services.PostfixMap
+	if err := c.Read(&lookup); err != nil {
+		return err
+	}
+
+	return lookup.Create()
+}
+
+func actionSmtpMapUpdate(c *routing.Context) error {
+	var lookup services.PostfixMap
+	if err := c.Read(&lookup); err != nil {
+		return err
+	}
+
+	return lookup.Update()
+}
+
+func actionSmtpMapDelete(c *routing.Context) error {
+	var lookup services.PostfixMap
+	if err := c.Read(&lookup); err != nil {
+		return err
+	}
+
+	return lookup.Delete()
+}
+
+func actionSmtpQueue(c *routing.Context) ([]mail.Message, error) {
+	var queue services.MailQueue
+	if err := c.Read(&queue); err != nil {
+		return nil, err
+	}
+
+	return queue.Messages()
+}
+
+func actionSmtpQueueHeaders(c *routing.Context) ([]mail.Header, error) {
+	var queue services.MailQueue
+	if err := c.Read(&queue); err != nil {
+		return nil, err
+	}
+
+	return queue.Headers()
+}
+
+func actionSmtpQueueFlush(c *routing.Context) ([]string, error) {
+	var queue services.MailQueue
+	if err := c.Read(&queue); err != nil {
+		return nil, err
+	}
+
+	return queue.Flush()
+}
+
+func actionSmtpQueueHold(c *routing.Context) ([]string, error) {
+	var queue services.MailQueue
+	if err := c.Read(&queue); err != nil {
+		return nil, err
+	}
+
+	return queue.Hold()
+}
+
+func actionSmtpQueueRelease(c *routing.Context) ([]string, error) {
+	var queue services.MailQueue
+	if err := c.Read(&queue); err != nil {
+		return nil, err
+	}
+
+	return queue.Release()
+}
+
+func actionSmtpQueueDelete(c *routing.Context) ([]string, error) {
+	var queue services.MailQueue
+	if err := c.Read(&queue); err != nil {
+		return nil, err
+	}
+
+	return queue.Delete()
+}
+
+func actionSmtpLogSearch(c *routing.Context) ([]mail.Event, error) {
+	var search services.MailLogSearch
+	if err := c.Read(&search); err != nil {
+		return nil, err
+	}
+
+	return search.Search()
+}
+
+func actionModuleDownload(c *routing.Context, module services.Module) error {
+	var files services.ModuleString
+	if err := c.Read(&files); err != nil {
 		return err
 	}
 
-	return smtp.TechMailDownload()
+	return module.Download(&files)
 }
 
-func actionSquidDownload(c *routing.Context) error {
+func actionModuleRead(c *routing.Context, module services.Module) (services.ModuleFile, error) {
+	var file services.ModuleFile
+	if err := c.Read(&file); err != nil {
+		return file, err
+	}
+
+	return module.Read(file.Name)
+}
+
+func actionModuleDiff(c *routing.Context, module services.Module) (string, error) {
+	var file services.ModuleFile
+	if err := c.Read(&file); err != nil {
+		return "", err
+	}
+
+	return module.Diff(file.Name, file.Data)
+}
+
+func actionModuleRollback(c *routing.Context, module services.Module) error {
+	var file services.ModuleFile
+	if err := c.Read(&file); err != nil {
+		return err
+	}
+
+	return module.Rollback(file.Name)
+}
+
+func actionSquidDownload(c *routing.Context) (bool, error) {
 	var squid services.SquidString
 	if err := c.Read(&squid); err != nil {
-		return err
+		return false, err
 	}
 
 	return squid.Download()
 }
 
-func actionSambaDownload(c *routing.Context) error {
+func actionSquidAclEntries(c *routing.Context) ([]string, error) {
+	var acl services.SquidAclEntries
+	if err := c.Read(&acl); err != nil {
+		return nil, err
+	}
+
+	return acl.List()
+}
+
+func actionSquidAclAdd(c *routing.Context) error {
+	var acl services.SquidAclEntries
+	if err := c.Read(&acl); err != nil {
+		return err
+	}
+
+	return acl.Add()
+}
+
+func actionSquidAclRemove(c *routing.Context) error {
+	var acl services.SquidAclEntries
+	if err := c.Read(&acl); err != nil {
+		return err
+	}
+
+	return acl.Remove()
+}
+
+func actionSquidStats(c *routing.Context) (squid.Stats, error) {
+	var stats services.SquidStats
+	if err := c.Read(&stats); err != nil {
+		return squid.Stats{}, err
+	}
+
+	return stats.Stats()
+}
+
+func actionSambaDownload(c *routing.Context) (bool, error) {
 	var samba services.ShareString
 	if err := c.Read(&samba); err != nil {
-		return err
+		return false, err
 	}
 
 	return samba.Download()
@@ -132,6 +355,24 @@ func actionSambaCreate(c *routing.Context) error {
 	return samba.Create()
 }
 
+func actionSambaUpdate(c *routing.Context) error {
+	var samba services.ShareString
+	if err := c.Read(&samba); err != nil {
+		return err
+	}
+
+	return samba.Update()
+}
+
+func actionSambaRename(c *routing.Context) error {
+	var samba services.ShareString
+	if err := c.Read(&samba); err != nil {
+		return err
+	}
+
+	return samba.Rename()
+}
+
 func actionSambaQuota(c *routing.Context) error {
 	var samba services.ShareString
 	if err := c.Read(&samba); err != nil {
@@ -141,6 +382,28 @@ func actionSambaQuota(c *routing.Context) error {
 	return samba.Quota()
 }
 
+func actionSambaUsage() ([]services.QuotaUsage, error) {
+	return services.QuotaUsages()
+}
+
+func actionSambaPermissions(c *routing.Context) error {
+	var permissions services.Permissions
+	if err := c.Read(&permissions); err != nil {
+		return err
+	}
+
+	return permissions.Apply()
+}
+
+func actionSambaReadPermissions(c *routing.Context) (services.Permissions, error) {
+	var permissions services.Permissions
+	if err := c.Read(&permissions); err != nil {
+		return services.Permissions{}, err
+	}
+
+	return permissions.Read()
+}
+
 func actionSambaDelete(c *routing.Context) error {
 	var samba services.ShareString
 	if err := c.Read(&samba); err != nil {
@@ -158,3 +421,252 @@ func actionSambaBackup(c *routing.Context) error {
 
 	return samba.Backup()
 }
+
+func actionSambaBackupStatus() backup.Status {
+	return backup.JobStatus()
+}
+
+func actionSambaSnapshots(c *routing.Context) (backup.ShareSnapshots, error) {
+	var samba backup.Restore
+	if err := c.Read(&samba); err != nil {
+		return backup.ShareSnapshots{}, err
+	}
+
+	return samba.Snapshots()
+}
+
+func actionSambaRestore(c *routing.Context) error {
+	var samba backup.Restore
+	if err := c.Read(&samba); err != nil {
+		return err
+	}
+
+	return samba.RestoreFile(services.ShareSettings.Mode)
+}
+
+func actionSambaRollback(c *routing.Context) error {
+	var samba backup.Restore
+	if err := c.Read(&samba); err != nil {
+		return err
+	}
+
+	return samba.Rollback()
+}
+
+func actionSambaPull(c *routing.Context) error {
+	var samba backup.Restore
+	if err := c.Read(&samba); err != nil {
+		return err
+	}
+
+	return samba.Pull()
+}
+
+func actionSambaTrash() ([]services.TrashedShare, error) {
+	return services.TrashedShares()
+}
+
+func actionSambaUndelete(c *routing.Context) error {
+	var share services.TrashedShare
+	if err := c.Read(&share); err != nil {
+		return err
+	}
+
+	return share.Undelete()
+}
+
+func actionSambaPurge() error {
+	return services.PurgeTrash()
+}
+
+func actionDatasetUsage(c *routing.Context) (services.DatasetUsage, error) {
+	var dataset services.Dataset
+	if err := c.Read(&dataset); err != nil {
+		return services.DatasetUsage{}, err
+	}
+
+	return dataset.Usage()
+}
+
+func actionDatasetProperties(c *routing.Context) error {
+	var dataset services.Dataset
+	if err := c.Read(&dataset); err != nil {
+		return err
+	}
+
+	return dataset.SetProperties()
+}
+
+func actionDatasetRename(c *routing.Context) error {
+	var dataset services.Dataset
+	if err := c.Read(&dataset); err != nil {
+		return err
+	}
+
+	return dataset.Rename()
+}
+
+func actionSambaUsers() ([]services.SambaUserInfo, error) {
+	return services.SambaUsers()
+}
+
+func actionSambaUserCreate(c *routing.Context) error {
+	var user services.SambaUser
+	if err := c.Read(&user); err != nil {
+		return err
+	}
+
+	return user.Create()
+}
+
+func actionSambaUserDisable(c *routing.Context) error {
+	var user services.SambaUser
+	if err := c.Read(&user); err != nil {
+		return err
+	}
+
+	return user.Disable()
+}
+
+func actionSambaUserPassword(c *routing.Context) error {
+	var user services.SambaUser
+	if err := c.Read(&user); err != nil {
+		return err
+	}
+
+	return user.ResetPassword()
+}
+
+func actionSambaUserDelete(c *routing.Context) error {
+	var user services.SambaUser
+	if err := c.Read(&user); err != nil {
+		return err
+	}
+
+	return user.Delete()
+}
+
+func actionSambaGroups() ([]services.SambaGroup, error) {
+	return services.SambaGroups()
+}
+
+func actionSambaGroupCreate(c *routing.Context) error {
+	var group services.SambaGroup
+	if err := c.Read(&group); err != nil {
+		return err
+	}
+
+	return group.Create()
+}
+
+func actionSambaGroupDelete(c *routing.Context) error {
+	var group services.SambaGroup
+	if err := c.Read(&group); err != nil {
+		return err
+	}
+
+	return group.Delete()
+}
+
+func actionSambaGroupMemberAdd(c *routing.Context) error {
+	var group services.SambaGroup
+	if err := c.Read(&group); err != nil {
+		return err
+	}
+
+	return group.AddMember()
+}
+
+func actionSambaGroupMemberRemove(c *routing.Context) error {
+	var group services.SambaGroup
+	if err := c.Read(&group); err != nil {
+		return err
+	}
+
+	return group.RemoveMember()
+}
+
+func actionSambaSessions(c *routing.Context) ([]samba.Session, error) {
+	var filter services.SessionFilter
+	if err := c.Read(&filter); err != nil {
+		return nil, err
+	}
+
+	return filter.Sessions()
+}
+
+func actionSambaConnections(c *routing.Context) ([]samba.Connection, error) {
+	var filter services.SessionFilter
+	if err := c.Read(&filter); err != nil {
+		return nil, err
+	}
+
+	return filter.Connections()
+}
+
+func actionSambaLocks(c *routing.Context) ([]samba.Lock, error) {
+	var filter services.SessionFilter
+	if err := c.Read(&filter); err != nil {
+		return nil, err
+	}
+
+	return filter.Locks()
+}
+
+func actionSambaDisconnect(c *routing.Context) error {
+	var session services.SessionClose
+	if err := c.Read(&session); err != nil {
+		return err
+	}
+
+	return session.Disconnect()
+}
+
+func actionSambaCloseShare(c *routing.Context) error {
+	var session services.SessionClose
+	if err := c.Read(&session); err != nil {
+		return err
+	}
+
+	return session.CloseShare()
+}
+
+func actionNfsDownload(c *routing.Context) error {
+	var nfs services.NfsString
+	if err := c.Read(&nfs); err != nil {
+		return err
+	}
+
+	return nfs.Download()
+}
+
+func actionNfsExports() ([]services.NfsExport, error) {
+	return services.NfsExports()
+}
+
+func actionNfsCreate(c *routing.Context) error {
+	var export services.NfsExport
+	if err := c.Read(&export); err != nil {
+		return err
+	}
+
+	return export.Create()
+}
+
+func actionNfsUpdate(c *routing.Context) error {
+	var export services.NfsExport
+	if err := c.Read(&export); err != nil {
+		return err
+	}
+
+	return export.Update()
+}
+
+func actionNfsDelete(c *routing.Context) error {
+	var export services.NfsExport
+	if err := c.Read(&export); err != nil {
+		return err
+	}
+
+	return export.Delete()
+}
@@ -0,0 +1,382 @@
+package mail
+
+import (
+	"bufio"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	TargetAddress = "address"
+	TargetFile    = "file"
+	TargetPipe    = "pipe"
+	TargetInclude = "include"
+)
+
+// Target is one recipient of an alias or a forward file: an address or local name, a file, a pipe or an include
+type Target struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Alias is one entry of the aliases file, a local name with its targets
+type Alias struct {
+	Name    string   `json:"name"`
+	Targets []Target `json:"targets"`
+}
+
+// Aliases is the part of the aliases file managed by the agent, Head holds the entries of aliases.head
+type Aliases struct {
+	Head    []*Alias
+	Entries []*Alias
+}
+
+// Resolver returns the targets of an included file, it is used to follow includes while looking for loops
+type Resolver func(path string) []Target
+
+var ErrAliasNotExist = errors.New("alias does not exist")
+
+func ParseAliases(head string, recv string) (*Aliases, error) {
+	headEntries, err := ParseEntries(head)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ParseEntries(recv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Aliases{Head: headEntries, Entries: entries}, nil
+}
+
+// ParseEntries parses aliases text, comments are dropped and lines starting with a blank continue the entry
+func ParseEntries(text string) ([]*Alias, error) {
+	var entries []*Alias
+	var lines []string
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		name, value, found := strings.Cut(line, ":")
+		if !found || strings.TrimSpace(name) == "" {
+			return nil, errors.New("Invalid alias: " + line)
+		}
+		targets, err := ParseTargets(value)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &Alias{Name: strings.TrimSpace(name), Targets: targets})
+	}
+
+	return entries, nil
+}
+
+// ParseForward parses the targets of a forward or :include: file, lines starting with # are comments
+func ParseForward(text string) ([]Target, error) {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	return ParseTargets(strings.Join(lines, "\n"))
+}
+
+// ParseTargets splits a comma or newline separated list of targets, commas inside double quotes are kept
+func ParseTargets(text string) ([]Target, error) {
+	var targets []Target
+	var part strings.Builder
+
+	quoted := false
+	flush := func() error {
+		value := strings.TrimSpace(part.String())
+		part.Reset()
+		if value == "" {
+			return nil
+		}
+		target, err := ParseTarget(value)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+		return nil
+	}
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			part.WriteRune(r)
+		case !quoted && (r == ',' || r == '\n'):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		default:
+			part.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, errors.New("Unterminated quote: " + strings.TrimSpace(text))
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+func ParseTarget(text string) (Target, error) {
+	value := strings.TrimSpace(text)
+	if len(value) > 1 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	}
+	if value == "" || strings.ContainsAny(value, "\r\n") {
+		return Target{}, errors.New("Invalid target: " + text)
+	}
+
+	switch {
+	case strings.HasPrefix(value, ":include:"):
+		return Target{TargetInclude, strings.TrimSpace(strings.TrimPrefix(value, ":include:"))}, nil
+	case strings.HasPrefix(value, "|"):
+		return Target{TargetPipe, strings.TrimSpace(strings.TrimPrefix(value, "|"))}, nil
+	case strings.HasPrefix(value, "/"):
+		return Target{TargetFile, value}, nil
+	}
+	if strings.ContainsAny(value, " \t,:\"") {
+		return Target{}, errors.New("Invalid target: " + text)
+	}
+
+	return Target{TargetAddress, value}, nil
+}
+
+func (t Target) Render() string {
+	switch t.Type {
+	case TargetInclude:
+		return ":include:" + t.Value
+	case TargetPipe:
+		return `"|` + t.Value + `"`
+	}
+
+	return t.Value
+}
+
+// Equal compares the targets, addresses ignore case
+func (t Target) Equal(target Target) bool {
+	if t.Type == TargetAddress && target.Type == TargetAddress {
+		return strings.EqualFold(t.Value, target.Value)
+	}
+
+	return t.Type == target.Type && t.Value == target.Value
+}
+
+// local returns the alias name an address target expands to, escaped names and remote addresses are not expanded
+func (t Target) local() (string, bool) {
+	if t.Type != TargetAddress || strings.HasPrefix(t.Value, `\`) || strings.Contains(t.Value, "@") {
+		return "", false
+	}
+
+	return strings.ToLower(t.Value), true
+}
+
+func (a *Alias) Validate() error {
+	if a.Name == "" || strings.ContainsAny(a.Name, ": \t\r\n#,") {
+		return errors.New("Invalid alias name: " + a.Name)
+	}
+	if len(a.Targets) == 0 {
+		return errors.New("Alias has no targets: " + a.Name)
+	}
+	for i, target := range a.Targets {
+		if _, err := ParseTarget(target.Render()); err != nil {
+			return err
+		}
+		for _, other := range a.Targets[:i] {
+			if target.Equal(other) {
+				return errors.New("Duplicate target of alias " + a.Name + ": " + target.Render())
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *Alias) Render() string {
+	targets := make([]string, 0, len(a.Targets))
+	for _, target := range a.Targets {
+		targets = append(targets, target.Render())
+	}
+
+	return a.Name + ": " + strings.Join(targets, ", ") + "\n"
+}
+
+func (a *Aliases) Alias(name string) (*Alias, error) {
+	for _, alias := range a.Entries {
+		if strings.EqualFold(alias.Name, name) {
+			return alias, nil
+		}
+	}
+
+	return nil, errors.New(ErrAliasNotExist.Error() + ": " + name)
+}
+
+// AddTarget appends the target to the alias, the alias is created when it does not exist
+func (a *Aliases) AddTarget(name string, target Target) error {
+	alias, err := a.Alias(name)
+	if err != nil {
+		a.Entries = append(a.Entries, &Alias{Name: name, Targets: []Target{target}})
+		return nil
+	}
+	for _, existing := range alias.Targets {
+		if existing.Equal(target) {
+			return errors.New("Duplicate target of alias " + alias.Name + ": " + target.Render())
+		}
+	}
+	alias.Targets = append(alias.Targets, target)
+
+	return nil
+}
+
+// RemoveTarget removes the target from the alias, the alias is deleted with its last target
+func (a *Aliases) RemoveTarget(name string, target Target) error {
+	alias, err := a.Alias(name)
+	if err != nil {
+		return err
+	}
+	for i, existing := range alias.Targets {
+		if existing.Equal(target) {
+			alias.Targets = append(alias.Targets[:i], alias.Targets[i+1:]...)
+			if len(alias.Targets) == 0 {
+				return a.Delete(alias.Name)
+			}
+			return nil
+		}
+	}
+
+	return errors.New("Alias " + alias.Name + " has no target: " + target.Render())
+}
+
+func (a *Aliases) Delete(name string) error {
+	for i, alias := range a.Entries {
+		if strings.EqualFold(alias.Name, name) {
+			a.Entries = append(a.Entries[:i], a.Entries[i+1:]...)
+			return nil
+		}
+	}
+
+	return errors.New(ErrAliasNotExist.Error() + ": " + name)
+}
+
+// Validate checks the entries, duplicate names with the head included and loops through local names and includes
+func (a *Aliases) Validate(resolve Resolver) error {
+	graph := make(map[string][]Target)
+	for _, alias := range append(append([]*Alias(nil), a.Head...), a.Entries...) {
+		if err := alias.Validate(); err != nil {
+			return err
+		}
+		name := strings.ToLower(alias.Name)
+		if _, ok := graph[name]; ok {
+			return errors.New("Duplicate alias: " + alias.Name)
+		}
+		graph[name] = alias.Targets
+	}
+
+	names := make([]string, 0, len(graph))
+	for name := range graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	done := make(map[string]bool)
+	for _, name := range names {
+		if err := visit(name, graph, resolve, done, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// visit walks the names the alias expands to, a name already on the path is a loop, except the alias itself
+// as a target, which delivers to the local mailbox ("john: john, john@elsewhere")
+func visit(name string, graph map[string][]Target, resolve Resolver, done map[string]bool, path []string) error {
+	for i, previous := range path {
+		if previous == name {
+			return errors.New("Alias loop: " + strings.Join(append(path[i:], name), " -> "))
+		}
+	}
+	if done[name] {
+		return nil
+	}
+	path = append(path, name)
+
+	for _, next := range expand(graph[name], resolve, make(map[string]bool)) {
+		if _, ok := graph[next]; !ok || next == name {
+			continue
+		}
+		if err := visit(next, graph, resolve, done, path); err != nil {
+			return err
+		}
+	}
+	done[name] = true
+
+	return nil
+}
+
+// expand returns the local names of the targets, following includes once each
+func expand(targets []Target, resolve Resolver, included map[string]bool) []string {
+	var names []string
+	for _, target := range targets {
+		if name, ok := target.local(); ok {
+			names = append(names, name)
+			continue
+		}
+		if target.Type == TargetInclude && resolve != nil && !included[target.Value] {
+			included[target.Value] = true
+			names = append(names, expand(resolve(target.Value), resolve, included)...)
+		}
+	}
+
+	return names
+}
+
+// Render returns the entries sorted by name
+func (a *Aliases) Render() string {
+	entries := append([]*Alias(nil), a.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+
+	var builder strings.Builder
+	for _, alias := range entries {
+		builder.WriteString(alias.Render())
+	}
+
+	return builder.String()
+}
+
+// RenderTargets writes the targets of a forward file, one per line in the given order
+func RenderTargets(targets []Target) string {
+	var builder strings.Builder
+	for _, target := range targets {
+		builder.WriteString(target.Render() + "\n")
+	}
+
+	return builder.String()
+}
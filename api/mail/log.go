@@ -0,0 +1,149 @@
+package mail
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a delivery attempt of a message to one recipient, taken from the to= line of the delivery agent
+// and completed with the from= line of qmgr, or a recipient rejected before the message was queued
+type Event struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	QueueId  string    `json:"queue_id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	OrigTo   string    `json:"orig_to"`
+	Relay    string    `json:"relay"`
+	Status   string    `json:"status"`
+	Dsn      string    `json:"dsn"`
+	Delay    float64   `json:"delay"`
+	Response string    `json:"response"`
+}
+
+var (
+	syslogLine = regexp.MustCompile(`^(\w{3}\s+\d+ \d{2}:\d{2}:\d{2}|\d{4}-\d{2}-\d{2}T\S+)\s+(\S+)\s+postfix[^:]*\[\d+\]:\s+([0-9A-Za-z]+):\s+(.*)$`)
+	logField   = regexp.MustCompile(`(\w+)=(<[^>]*>|[^,\s]*)`)
+	dsnCode    = regexp.MustCompile(`\b[245]\d\d ([245]\.\d{1,3}\.\d{1,3})\b`)
+)
+
+// ParseLog parses postfix lines of syslog or journal text, both "Jan 2 15:04:05" and RFC 3339 stamps are read,
+// the first have no year and a time in the future belongs to last year
+func ParseLog(text string, now time.Time) []Event {
+	var events []Event
+	senders := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := syslogLine.FindStringSubmatch(scanner.Text())
+		if fields == nil {
+			continue
+		}
+		queueId, message := fields[3], fields[4]
+		if queueId == "NOQUEUE" {
+			if event, ok := rejectEvent(message); ok {
+				event.Time, event.Host = logTime(fields[1], now), fields[2]
+				events = append(events, event)
+			}
+			continue
+		}
+		values := logValues(message)
+
+		to, ok := values["to"]
+		if !ok {
+			if from, ok := values["from"]; ok {
+				senders[queueId] = from
+			}
+			continue
+		}
+		from, ok := values["from"]
+		if !ok {
+			from = senders[queueId]
+		}
+
+		event := Event{
+			Time:    logTime(fields[1], now),
+			Host:    fields[2],
+			QueueId: queueId,
+			From:    from,
+			To:      to,
+			OrigTo:  values["orig_to"],
+			Relay:   values["relay"],
+			Status:  values["status"],
+			Dsn:     values["dsn"],
+		}
+		event.Delay, _ = strconv.ParseFloat(values["delay"], 64)
+		if start := strings.Index(message, "status="+event.Status+" ("); start >= 0 {
+			event.Response = strings.TrimSuffix(message[start+len("status="+event.Status+" ("):], ")")
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// rejectEvent parses a message refused before it was queued, "reject: RCPT from host: 554 5.7.1 reason;
+// from=<a> to=<b> proto=ESMTP", the action is the status and the text up to the last "; " the response
+func rejectEvent(message string) (Event, bool) {
+	action, rest, found := strings.Cut(message, ": ")
+	end := strings.LastIndex(rest, "; ")
+	if !found || end < 0 {
+		return Event{}, false
+	}
+	values := make(map[string]string)
+	for _, match := range logField.FindAllStringSubmatch(rest[end+2:], -1) {
+		values[match[1]] = strings.TrimSuffix(strings.TrimPrefix(match[2], "<"), ">")
+	}
+	to, ok := values["to"]
+	if !ok {
+		return Event{}, false
+	}
+
+	event := Event{QueueId: "NOQUEUE", From: values["from"], To: to, Status: action, Response: rest[:end]}
+	if code := dsnCode.FindStringSubmatch(event.Response); code != nil {
+		event.Dsn = code[1]
+	}
+
+	return event, true
+}
+
+// logValues returns the key=value pairs before the response text, angle brackets of addresses are removed
+func logValues(message string) map[string]string {
+	if end := strings.Index(message, " ("); end >= 0 {
+		message = message[:end]
+	}
+
+	values := make(map[string]string)
+	for _, match := range logField.FindAllStringSubmatch(message, -1) {
+		values[match[1]] = strings.TrimSuffix(strings.TrimPrefix(match[2], "<"), ">")
+	}
+
+	return values
+}
+
+func logTime(value string, now time.Time) time.Time {
+	if stamp, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return stamp
+	}
+
+	stamp, err := time.ParseInLocation("Jan 2 15:04:05", strings.Join(strings.Fields(value), " "), now.Location())
+	if err != nil {
+		return time.Time{}
+	}
+
+	return withYear(stamp, now)
+}
+
+// withYear moves a stamp parsed without year into the year of now, or the year before when it would be in the future
+func withYear(stamp time.Time, now time.Time) time.Time {
+	stamp = stamp.AddDate(now.Year()-stamp.Year(), 0, 0)
+	if stamp.After(now.Add(24 * time.Hour)) {
+		stamp = stamp.AddDate(-1, 0, 0)
+	}
+
+	return stamp
+}
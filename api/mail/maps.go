@@ -0,0 +1,169 @@
+package mail
+
+import (
+	"bufio"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Entry is one line of a postfix lookup table, the key and the rest of the line as value
+type Entry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Map is the part of a lookup table managed by the agent, Head holds the entries of the .head file
+type Map struct {
+	Head    []*Entry
+	Entries []*Entry
+}
+
+var ErrEntryNotExist = errors.New("map entry does not exist")
+
+func ParseMap(head string, recv string) (*Map, error) {
+	headEntries, err := ParseMapEntries(head)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ParseMapEntries(recv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Map{Head: headEntries, Entries: entries}, nil
+}
+
+// ParseMapEntries parses a lookup table the way postmap does, lines starting with a blank continue the entry
+func ParseMapEntries(text string) ([]*Entry, error) {
+	var entries []*Entry
+	var lines []string
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, errors.New("Invalid map entry: " + line)
+		}
+		entries = append(entries, &Entry{Key: fields[0], Value: strings.Join(fields[1:], " ")})
+	}
+
+	return entries, nil
+}
+
+func (e *Entry) Validate() error {
+	if e.Key == "" || strings.ContainsAny(e.Key, " \t\r\n") || strings.HasPrefix(e.Key, "#") {
+		return errors.New("Invalid map key: " + e.Key)
+	}
+	if strings.TrimSpace(e.Value) == "" || strings.ContainsAny(e.Value, "\r\n") {
+		return errors.New("Invalid map value of key " + e.Key)
+	}
+
+	return nil
+}
+
+func (m *Map) Entry(key string) (*Entry, error) {
+	for _, entry := range m.Entries {
+		if strings.EqualFold(entry.Key, key) {
+			return entry, nil
+		}
+	}
+
+	return nil, errors.New(ErrEntryNotExist.Error() + ": " + key)
+}
+
+func (m *Map) Add(entry *Entry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+	if m.isTaken(entry.Key) {
+		return errors.New("Duplicate map key: " + entry.Key)
+	}
+
+	m.Entries = append(m.Entries, entry)
+
+	return nil
+}
+
+func (m *Map) Update(entry *Entry) error {
+	if err := entry.Validate(); err != nil {
+		return err
+	}
+
+	existing, err := m.Entry(entry.Key)
+	if err != nil {
+		return err
+	}
+	existing.Value = entry.Value
+
+	return nil
+}
+
+func (m *Map) Delete(key string) error {
+	for i, entry := range m.Entries {
+		if strings.EqualFold(entry.Key, key) {
+			m.Entries = append(m.Entries[:i], m.Entries[i+1:]...)
+			return nil
+		}
+	}
+
+	return errors.New(ErrEntryNotExist.Error() + ": " + key)
+}
+
+// Validate checks the entries and the keys for duplicates, postfix matches the keys ignoring case
+func (m *Map) Validate() error {
+	keys := make(map[string]bool)
+	for _, entry := range append(append([]*Entry(nil), m.Head...), m.Entries...) {
+		if err := entry.Validate(); err != nil {
+			return err
+		}
+		key := strings.ToLower(entry.Key)
+		if keys[key] {
+			return errors.New("Duplicate map key: " + entry.Key)
+		}
+		keys[key] = true
+	}
+
+	return nil
+}
+
+// Render returns the entries sorted by key
+func (m *Map) Render() string {
+	entries := append([]*Entry(nil), m.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.ToLower(entries[i].Key) < strings.ToLower(entries[j].Key)
+	})
+
+	var builder strings.Builder
+	for _, entry := range entries {
+		builder.WriteString(entry.Key + "\t" + strings.TrimSpace(entry.Value) + "\n")
+	}
+
+	return builder.String()
+}
+
+func (m *Map) isTaken(key string) bool {
+	for _, entry := range m.Head {
+		if strings.EqualFold(entry.Key, key) {
+			return true
+		}
+	}
+	_, err := m.Entry(key)
+
+	return err == nil
+}
@@ -0,0 +1,197 @@
+package mail
+
+import (
+	"bufio"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a message in the postfix queue
+type Message struct {
+	Id         string      `json:"id"`
+	Queue      string      `json:"queue"`
+	Arrival    time.Time   `json:"arrival"`
+	Size       int64       `json:"size"`
+	Sender     string      `json:"sender"`
+	Recipients []Recipient `json:"recipients"`
+}
+
+type Recipient struct {
+	Address string `json:"address"`
+	Reason  string `json:"reason"`
+}
+
+// Header is one header of a queued message, folded lines are joined
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var (
+	QueueId   = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+	mailqLine = regexp.MustCompile(`^([0-9A-Za-z]+)([*!]?)\s+(\d+)\s+(\w{3} \w{3}\s+\d+ \d{2}:\d{2}:\d{2})\s+(.*)$`)
+)
+
+// ParseQueueJson parses postqueue -j, one json object per message, warnings between them are skipped
+func ParseQueueJson(output string) ([]Message, error) {
+	messages := []Message{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+
+		var message struct {
+			QueueName   string `json:"queue_name"`
+			QueueId     string `json:"queue_id"`
+			ArrivalTime int64  `json:"arrival_time"`
+			MessageSize int64  `json:"message_size"`
+			Sender      string `json:"sender"`
+			Recipients  []struct {
+				Address     string `json:"address"`
+				DelayReason string `json:"delay_reason"`
+			} `json:"recipients"`
+		}
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			return nil, err
+		}
+
+		parsed := Message{
+			Id:      message.QueueId,
+			Queue:   message.QueueName,
+			Arrival: time.Unix(message.ArrivalTime, 0),
+			Size:    message.MessageSize,
+			Sender:  message.Sender,
+		}
+		for _, recipient := range message.Recipients {
+			parsed.Recipients = append(parsed.Recipients, Recipient{recipient.Address, recipient.DelayReason})
+		}
+		messages = append(messages, parsed)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// ParseMailq parses the mailq output of postfix, "*" marks the active queue and "!" the hold queue,
+// a reason in parentheses applies to the recipients below it
+func ParseMailq(output string, now time.Time) []Message {
+	messages := []Message{}
+	var message *Message
+	reason := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			if message != nil {
+				messages = append(messages, *message)
+				message = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "-") || strings.HasPrefix(trimmed, "Mail queue is empty") {
+			continue
+		}
+
+		if fields := mailqLine.FindStringSubmatch(line); fields != nil {
+			if message != nil {
+				messages = append(messages, *message)
+			}
+			size, _ := strconv.ParseInt(fields[3], 10, 64)
+			message = &Message{
+				Id:      fields[1],
+				Queue:   mailqQueue(fields[2]),
+				Arrival: mailqArrival(fields[4], now),
+				Size:    size,
+				Sender:  strings.TrimSpace(fields[5]),
+			}
+			reason = ""
+			continue
+		}
+		if message == nil {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "(") && strings.HasSuffix(trimmed, ")") {
+			reason = trimmed[1 : len(trimmed)-1]
+			continue
+		}
+		message.Recipients = append(message.Recipients, Recipient{trimmed, reason})
+	}
+	if message != nil {
+		messages = append(messages, *message)
+	}
+
+	return messages
+}
+
+// ParseHeaders parses postcat -h output, the "*** ... ***" record markers are skipped
+func ParseHeaders(output string) []Header {
+	var headers []Header
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "*** ") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(headers) > 0 {
+			headers[len(headers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		headers = append(headers, Header{strings.TrimSpace(name), strings.TrimSpace(value)})
+	}
+
+	return headers
+}
+
+// Match reports whether the sender or any recipient matches the patterns, an empty pattern matches everything
+func (m Message) Match(sender *regexp.Regexp, recipient *regexp.Regexp) bool {
+	if sender != nil && !sender.MatchString(m.Sender) {
+		return false
+	}
+	if recipient == nil {
+		return true
+	}
+	for _, address := range m.Recipients {
+		if recipient.MatchString(address.Address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mailqQueue(mark string) string {
+	switch mark {
+	case "*":
+		return "active"
+	case "!":
+		return "hold"
+	}
+
+	return "deferred"
+}
+
+// mailqArrival parses the arrival time of mailq, which has no year
+func mailqArrival(value string, now time.Time) time.Time {
+	arrival, err := time.ParseInLocation("Mon Jan 2 15:04:05", strings.Join(strings.Fields(value), " "), now.Location())
+	if err != nil {
+		return time.Time{}
+	}
+
+	return withYear(arrival, now)
+}
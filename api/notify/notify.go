@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/getsentry/sentry-go"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type Notify struct {
+	Webhook string
+	Email   struct {
+		From string
+		To   []string
+	}
+	Sentry bool
+}
+
+type message struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+}
+
+var NotifySettings Notify
+
+// Send delivers the alert to every configured channel, Sentry is used when nothing is configured
+func Send(subject string, text string) error {
+	var errs []string
+
+	if NotifySettings.Webhook != "" {
+		if err := sendWebhook(subject, text); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(NotifySettings.Email.To) > 0 {
+		if err := sendEmail(subject, text); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if NotifySettings.Sentry || (NotifySettings.Webhook == "" && len(NotifySettings.Email.To) == 0) {
+		sentry.CaptureMessage(subject + ": " + text)
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func sendWebhook(subject string, text string) error {
+	body, err := json.Marshal(message{Subject: subject, Text: text})
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Post(NotifySettings.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return errors.New("Webhook responded " + response.Status)
+	}
+
+	return nil
+}
+
+// sendEmail hands the alert to the local MTA
+func sendEmail(subject string, text string) error {
+	from := NotifySettings.Email.From
+	if from == "" {
+		from = "root"
+	}
+
+	var mail strings.Builder
+	mail.WriteString("From: " + from + "\n")
+	mail.WriteString("To: " + strings.Join(NotifySettings.Email.To, ", ") + "\n")
+	mail.WriteString("Subject: " + strings.ReplaceAll(subject, "\n", " ") + "\n")
+	mail.WriteString("Content-Type: text/plain; charset=utf-8\n\n")
+	mail.WriteString(text + "\n")
+
+	cli := exec.Command("/usr/sbin/sendmail", "-t", "-i")
+	cli.Stdin = strings.NewReader(mail.String())
+	output, err := cli.CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
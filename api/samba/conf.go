@@ -0,0 +1,280 @@
+package samba
+
+import (
+	"bufio"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Section is one share of smb.conf, parameters without a typed field are kept in Parameters
+type Section struct {
+	Name       string            `json:"name"`
+	Path       string            `json:"path"`
+	ValidUsers []string          `json:"valid_users"`
+	ReadOnly   *bool             `json:"read_only"`
+	VfsObjects []string          `json:"vfs_objects"`
+	Parameters map[string]string `json:"parameters"`
+}
+
+// Conf is the part of smb.conf managed by the agent, Reserved holds the section names of smb.conf.head
+type Conf struct {
+	Reserved []string
+	Shares   []*Section
+}
+
+var ErrShareNotExist = errors.New("share does not exist")
+
+func ParseConf(head string, recv string) (*Conf, error) {
+	headSections, err := ParseSections(head)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Conf{}
+	for _, section := range headSections {
+		conf.Reserved = append(conf.Reserved, section.Name)
+	}
+
+	shares, err := ParseSections(recv)
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		if err = conf.Add(share); err != nil {
+			return nil, err
+		}
+	}
+
+	return conf, nil
+}
+
+// ParseSections parses smb.conf text, comments are dropped and continuation lines are joined
+func ParseSections(text string) ([]*Section, error) {
+	var sections []*Section
+	var section *Section
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	line := ""
+	for scanner.Scan() {
+		part := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(part, "\\") {
+			line += strings.TrimSpace(strings.TrimSuffix(part, "\\")) + " "
+			continue
+		}
+		line += part
+
+		current := strings.TrimSpace(line)
+		line = ""
+		if current == "" || strings.HasPrefix(current, "#") || strings.HasPrefix(current, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(current, "[") {
+			if !strings.HasSuffix(current, "]") {
+				return nil, errors.New("Invalid section header: " + current)
+			}
+			section = &Section{Name: strings.TrimSpace(current[1 : len(current)-1])}
+			sections = append(sections, section)
+			continue
+		}
+
+		key, value, found := strings.Cut(current, "=")
+		if !found {
+			return nil, errors.New("Invalid parameter: " + current)
+		}
+		if section == nil {
+			return nil, errors.New("Parameter outside of section: " + current)
+		}
+		section.Set(key, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
+
+// Set assigns a parameter, names are matched the way samba does, ignoring case and spaces
+func (s *Section) Set(key string, value string) {
+	value = strings.TrimSpace(value)
+	switch normalize(key) {
+	case "path":
+		s.Path = value
+	case "validusers":
+		s.ValidUsers = strings.Fields(strings.ReplaceAll(value, ",", " "))
+	case "readonly":
+		readOnly := isTrue(value)
+		s.ReadOnly = &readOnly
+	case "vfsobjects":
+		s.VfsObjects = strings.Fields(value)
+	default:
+		if s.Parameters == nil {
+			s.Parameters = make(map[string]string)
+		}
+		s.Parameters[strings.ToLower(strings.Join(strings.Fields(key), " "))] = value
+	}
+}
+
+// Validate rejects values that would break out of their line or start a new section in smb.conf
+func (s *Section) Validate() error {
+	if s.Name == "" || strings.ContainsAny(s.Name, "[]\r\n") {
+		return errors.New("Invalid share name: " + s.Name)
+	}
+	if strings.ContainsAny(s.Path, "[\r\n") {
+		return errors.New("Invalid path of share " + s.Name)
+	}
+	for _, user := range s.ValidUsers {
+		if strings.ContainsAny(user, "[\r\n") {
+			return errors.New("Invalid user of share " + s.Name + ": " + user)
+		}
+	}
+	for _, object := range s.VfsObjects {
+		if strings.ContainsAny(object, "[\r\n") {
+			return errors.New("Invalid vfs object of share " + s.Name + ": " + object)
+		}
+	}
+	for key, value := range s.Parameters {
+		if strings.ContainsAny(key, "=[\r\n") || strings.ContainsAny(value, "[\r\n") {
+			return errors.New("Invalid parameter of share " + s.Name + ": " + key)
+		}
+	}
+
+	return nil
+}
+
+// Render writes the section with its typed parameters first and the rest sorted by name
+func (s *Section) Render() string {
+	var builder strings.Builder
+
+	builder.WriteString("[" + s.Name + "]\n")
+	if s.Path != "" {
+		builder.WriteString("\tpath = " + s.Path + "\n")
+	}
+	if len(s.ValidUsers) > 0 {
+		builder.WriteString("\tvalid users = " + strings.Join(s.ValidUsers, " ") + "\n")
+	}
+	if s.ReadOnly != nil {
+		readOnly := "no"
+		if *s.ReadOnly {
+			readOnly = "yes"
+		}
+		builder.WriteString("\tread only = " + readOnly + "\n")
+	}
+	if len(s.VfsObjects) > 0 {
+		builder.WriteString("\tvfs objects = " + strings.Join(s.VfsObjects, " ") + "\n")
+	}
+
+	keys := make([]string, 0, len(s.Parameters))
+	for key := range s.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		builder.WriteString("\t" + key + " = " + s.Parameters[key] + "\n")
+	}
+
+	return builder.String()
+}
+
+func (c *Conf) Share(name string) (*Section, error) {
+	for _, share := range c.Shares {
+		if strings.EqualFold(share.Name, name) {
+			return share, nil
+		}
+	}
+
+	return nil, errors.New(ErrShareNotExist.Error() + ": " + name)
+}
+
+func (c *Conf) Add(share *Section) error {
+	if err := share.Validate(); err != nil {
+		return err
+	}
+	if c.isTaken(share.Name) {
+		return errors.New("Duplicate share name: " + share.Name)
+	}
+
+	c.Shares = append(c.Shares, share)
+
+	return nil
+}
+
+func (c *Conf) Update(share *Section) error {
+	if err := share.Validate(); err != nil {
+		return err
+	}
+
+	for i, existing := range c.Shares {
+		if strings.EqualFold(existing.Name, share.Name) {
+			c.Shares[i] = share
+			return nil
+		}
+	}
+
+	return errors.New(ErrShareNotExist.Error() + ": " + share.Name)
+}
+
+func (c *Conf) Delete(name string) error {
+	for i, share := range c.Shares {
+		if strings.EqualFold(share.Name, name) {
+			c.Shares = append(c.Shares[:i], c.Shares[i+1:]...)
+			return nil
+		}
+	}
+
+	return errors.New(ErrShareNotExist.Error() + ": " + name)
+}
+
+func (c *Conf) Rename(name string, newName string) error {
+	share, err := c.Share(name)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(name, newName) && c.isTaken(newName) {
+		return errors.New("Duplicate share name: " + newName)
+	}
+
+	share.Name = newName
+
+	return share.Validate()
+}
+
+// Render returns the shares sorted by name
+func (c *Conf) Render() string {
+	shares := append([]*Section(nil), c.Shares...)
+	sort.SliceStable(shares, func(i, j int) bool {
+		return strings.ToLower(shares[i].Name) < strings.ToLower(shares[j].Name)
+	})
+
+	var parts []string
+	for _, share := range shares {
+		parts = append(parts, share.Render())
+	}
+
+	return strings.Join(parts, "\n")
+}
+
+func (c *Conf) isTaken(name string) bool {
+	for _, reserved := range c.Reserved {
+		if strings.EqualFold(reserved, name) {
+			return true
+		}
+	}
+	_, err := c.Share(name)
+
+	return err == nil
+}
+
+func normalize(key string) string {
+	return strings.ToLower(strings.Join(strings.Fields(key), ""))
+}
+
+func isTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1", "on":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,335 @@
+package samba
+
+import (
+	"agent/api/command"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+type Status struct {
+	Sessions    []Session    `json:"sessions"`
+	Connections []Connection `json:"connections"`
+	Locks       []Lock       `json:"locks"`
+}
+
+type Session struct {
+	Pid        string `json:"pid"`
+	Username   string `json:"username"`
+	Group      string `json:"group"`
+	Machine    string `json:"machine"`
+	Protocol   string `json:"protocol"`
+	Encryption string `json:"encryption"`
+	Signing    string `json:"signing"`
+}
+
+type Connection struct {
+	Service     string `json:"service"`
+	Pid         string `json:"pid"`
+	Machine     string `json:"machine"`
+	ConnectedAt string `json:"connected_at"`
+	Encryption  string `json:"encryption"`
+	Signing     string `json:"signing"`
+}
+
+type Lock struct {
+	Pid       string `json:"pid"`
+	User      string `json:"user"`
+	DenyMode  string `json:"deny_mode"`
+	Access    string `json:"access"`
+	ReadWrite string `json:"read_write"`
+	Oplock    string `json:"oplock"`
+	SharePath string `json:"share_path"`
+	Name      string `json:"name"`
+	Time      string `json:"time"`
+}
+
+var runner command.Runner = command.Local{}
+
+// ReadStatus returns the smbstatus of the server, the json output is used when samba supports it
+func ReadStatus() (Status, error) {
+	output, err := runner.Run("/usr/bin/smbstatus", "--json")
+	if err == nil {
+		return ParseJson(output)
+	}
+
+	var status Status
+
+	output, err = runner.Run("/usr/bin/smbstatus", "-p")
+	if err != nil {
+		return status, errors.New(err.Error() + ": " + string(output))
+	}
+	status.Sessions = ParseSessions(string(output))
+
+	output, err = runner.Run("/usr/bin/smbstatus", "-S")
+	if err != nil {
+		return status, errors.New(err.Error() + ": " + string(output))
+	}
+	status.Connections = ParseConnections(string(output))
+
+	output, err = runner.Run("/usr/bin/smbstatus", "-L")
+	if err != nil {
+		return status, errors.New(err.Error() + ": " + string(output))
+	}
+	status.Locks = ParseLocks(string(output))
+
+	return status, nil
+}
+
+// CloseShare makes the smbd process release the share, the client reconnects on its next access
+func CloseShare(pid string, share string) error {
+	output, err := runner.Run("/usr/bin/smbcontrol", pid, "close-share", share)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+// Disconnect terminates the smbd process serving the session, open files of the client are closed
+func Disconnect(pid string) error {
+	output, err := runner.Run("/usr/bin/smbcontrol", pid, "shutdown")
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+type serverId struct {
+	Pid jsonString `json:"pid"`
+}
+
+// jsonString accepts both json strings and numbers, samba versions differ in how they print ids
+type jsonString string
+
+func (j *jsonString) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case string:
+		*j = jsonString(v)
+	case nil:
+		*j = ""
+	default:
+		*j = jsonString(strings.Trim(string(data), `"`))
+	}
+
+	return nil
+}
+
+type jsonStatus struct {
+	Sessions map[string]struct {
+		ServerId      serverId   `json:"server_id"`
+		Username      string     `json:"username"`
+		Groupname     string     `json:"groupname"`
+		RemoteMachine string     `json:"remote_machine"`
+		Hostname      string     `json:"hostname"`
+		Dialect       string     `json:"session_dialect"`
+		Encryption    jsonCipher `json:"encryption"`
+		Signing       jsonCipher `json:"signing"`
+	} `json:"sessions"`
+	Tcons map[string]struct {
+		Service     string     `json:"service"`
+		ServerId    serverId   `json:"server_id"`
+		Machine     string     `json:"machine"`
+		ConnectedAt string     `json:"connected_at"`
+		Encryption  jsonCipher `json:"encryption"`
+		Signing     jsonCipher `json:"signing"`
+	} `json:"tcons"`
+	OpenFiles map[string]struct {
+		ServicePath string `json:"service_path"`
+		Filename    string `json:"filename"`
+		Opens       map[string]struct {
+			ServerId  serverId   `json:"server_id"`
+			Uid       jsonString `json:"uid"`
+			OpenedAt  string     `json:"opened_at"`
+			ShareMode struct {
+				Text string `json:"text"`
+			} `json:"sharemode"`
+			AccessMask struct {
+				Hex  string `json:"hex"`
+				Text string `json:"text"`
+			} `json:"access_mask"`
+			Oplock struct {
+				Text string `json:"text"`
+			} `json:"oplock"`
+		} `json:"opens"`
+	} `json:"open_files"`
+}
+
+type jsonCipher struct {
+	Cipher string `json:"cipher"`
+	Degree string `json:"degree"`
+}
+
+func (c jsonCipher) String() string {
+	if c.Cipher == "" {
+		return "-"
+	}
+	if c.Degree == "" {
+		return c.Cipher
+	}
+
+	return c.Degree + "(" + c.Cipher + ")"
+}
+
+func ParseJson(output []byte) (Status, error) {
+	var status Status
+	var data jsonStatus
+	if err := json.Unmarshal(output, &data); err != nil {
+		return status, err
+	}
+
+	for _, session := range data.Sessions {
+		machine := session.RemoteMachine
+		if session.Hostname != "" {
+			machine += " (" + session.Hostname + ")"
+		}
+		status.Sessions = append(status.Sessions, Session{
+			Pid:        string(session.ServerId.Pid),
+			Username:   session.Username,
+			Group:      session.Groupname,
+			Machine:    machine,
+			Protocol:   session.Dialect,
+			Encryption: session.Encryption.String(),
+			Signing:    session.Signing.String(),
+		})
+	}
+
+	for _, tcon := range data.Tcons {
+		status.Connections = append(status.Connections, Connection{
+			Service:     tcon.Service,
+			Pid:         string(tcon.ServerId.Pid),
+			Machine:     tcon.Machine,
+			ConnectedAt: tcon.ConnectedAt,
+			Encryption:  tcon.Encryption.String(),
+			Signing:     tcon.Signing.String(),
+		})
+	}
+
+	for _, file := range data.OpenFiles {
+		for _, open := range file.Opens {
+			status.Locks = append(status.Locks, Lock{
+				Pid:       string(open.ServerId.Pid),
+				User:      string(open.Uid),
+				DenyMode:  open.ShareMode.Text,
+				Access:    open.AccessMask.Hex,
+				ReadWrite: open.AccessMask.Text,
+				Oplock:    open.Oplock.Text,
+				SharePath: file.ServicePath,
+				Name:      file.Filename,
+				Time:      open.OpenedAt,
+			})
+		}
+	}
+
+	status.sort()
+
+	return status, nil
+}
+
+// ParseSessions parses the text output of smbstatus -p
+func ParseSessions(output string) []Session {
+	var sessions []Session
+	for _, fields := range tableRows(output) {
+		if len(fields) < 7 {
+			continue
+		}
+		last := len(fields) - 3
+		sessions = append(sessions, Session{
+			Pid:        fields[0],
+			Username:   fields[1],
+			Group:      fields[2],
+			Machine:    strings.Join(fields[3:last], " "),
+			Protocol:   fields[last],
+			Encryption: fields[last+1],
+			Signing:    fields[last+2],
+		})
+	}
+
+	return sessions
+}
+
+// ParseConnections parses the text output of smbstatus -S
+func ParseConnections(output string) []Connection {
+	var connections []Connection
+	for _, fields := range tableRows(output) {
+		if len(fields) < 6 {
+			continue
+		}
+		last := len(fields) - 2
+		connections = append(connections, Connection{
+			Service:     fields[0],
+			Pid:         fields[1],
+			Machine:     fields[2],
+			ConnectedAt: strings.Join(fields[3:last], " "),
+			Encryption:  fields[last],
+			Signing:     fields[last+1],
+		})
+	}
+
+	return connections
+}
+
+// ParseLocks parses the text output of smbstatus -L, the time takes the last five columns
+func ParseLocks(output string) []Lock {
+	var locks []Lock
+	for _, fields := range tableRows(output) {
+		if len(fields) < 13 {
+			continue
+		}
+		last := len(fields) - 5
+		locks = append(locks, Lock{
+			Pid:       fields[0],
+			User:      fields[1],
+			DenyMode:  fields[2],
+			Access:    fields[3],
+			ReadWrite: fields[4],
+			Oplock:    fields[5],
+			SharePath: fields[6],
+			Name:      strings.Join(fields[7:last], " "),
+			Time:      strings.Join(fields[last:], " "),
+		})
+	}
+
+	return locks
+}
+
+// tableRows returns the fields of the rows below the dashed separator of an smbstatus table
+func tableRows(output string) [][]string {
+	var rows [][]string
+	inTable := false
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "---") {
+			inTable = true
+			continue
+		}
+		if line == "" {
+			inTable = false
+			continue
+		}
+		if inTable {
+			rows = append(rows, strings.Fields(line))
+		}
+	}
+
+	return rows
+}
+
+func (s *Status) sort() {
+	sort.SliceStable(s.Sessions, func(i, j int) bool { return s.Sessions[i].Pid < s.Sessions[j].Pid })
+	sort.SliceStable(s.Connections, func(i, j int) bool {
+		return s.Connections[i].Service+s.Connections[i].Pid < s.Connections[j].Service+s.Connections[j].Pid
+	})
+	sort.SliceStable(s.Locks, func(i, j int) bool {
+		return s.Locks[i].SharePath+"/"+s.Locks[i].Name < s.Locks[j].SharePath+"/"+s.Locks[j].Name
+	})
+}
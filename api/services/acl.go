@@ -0,0 +1,241 @@
+package services
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SquidAcl declares an acl list kept in its own file of prod, included in squid.conf as
+// acl <name> <type> "<prod>/<name>"
+type SquidAcl struct {
+	Name string
+	Type string
+}
+
+type SquidAclEntries struct {
+	Name    string   `json:"name" form:"name"`
+	Entries []string `json:"entries"`
+}
+
+var (
+	aclDomain = regexp.MustCompile(`^\.?[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?(\.[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?)*$`)
+	aclName   = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// List returns the entries of the acl file
+func (s *SquidAclEntries) List() ([]string, error) {
+	acl, err := squidAcl(s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return readAclEntries(acl)
+}
+
+func (s *SquidAclEntries) Add() error {
+	return changeAcl(s.Name, func(entries []string) []string {
+		return append(entries, s.Entries...)
+	})
+}
+
+func (s *SquidAclEntries) Remove() error {
+	acl, err := squidAcl(s.Name)
+	if err != nil {
+		return err
+	}
+	removed := make(map[string]bool)
+	for _, entry := range s.Entries {
+		normalized, err := acl.normalize(entry)
+		if err != nil {
+			return err
+		}
+		removed[normalized] = true
+	}
+
+	return changeAcl(s.Name, func(entries []string) []string {
+		var kept []string
+		for _, entry := range entries {
+			if normalized, err := acl.normalize(entry); err != nil || !removed[normalized] {
+				kept = append(kept, entry)
+			}
+		}
+		return kept
+	})
+}
+
+func squidAcl(name string) (SquidAcl, error) {
+	for _, acl := range SquidSettings.Acls {
+		if acl.Name == name && aclName.MatchString(name) {
+			return acl, nil
+		}
+	}
+
+	return SquidAcl{}, errors.New("Unknown squid acl: " + name)
+}
+
+// changeAcl applies the change to the entries of the acl, squid is reconfigured only when the rendered file
+// differs from prod, the old file is restored when squid -k parse rejects the new one or the reconfigure fails
+func changeAcl(name string, change func(entries []string) []string) error {
+	acl, err := squidAcl(name)
+	if err != nil {
+		return err
+	}
+	entries, err := readAclEntries(acl)
+	if err != nil {
+		return err
+	}
+	if entries, err = acl.render(change(entries)); err != nil {
+		return err
+	}
+
+	temp := SquidSettings.Path.Temp
+	head := temp + "/" + name + ".head"
+	recv := temp + "/" + name + ".recv"
+	conf := SquidSettings.Path.Prod + "/" + name
+	data := ""
+	if len(entries) > 0 {
+		data = strings.Join(entries, "\n") + "\n"
+	}
+
+	current, err := readOptional(conf)
+	if err != nil {
+		return err
+	}
+	headData, err := readOptional(head)
+	if err != nil {
+		return err
+	}
+	if current == headData+data {
+		return nil
+	}
+
+	backups, err := backupFiles(map[string]string{recv: recv + ".backup", conf: temp + "/" + name + ".prod.backup"})
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(recv, []byte(data), 0644); err != nil {
+		return backups.restore(err)
+	}
+	if err = commit(head, recv, conf); err != nil {
+		return backups.restore(err)
+	}
+	if err = parseSquid(); err != nil {
+		return backups.restore(err)
+	}
+	if err = restartSquid(); err != nil {
+		return backups.restore(err)
+	}
+
+	return nil
+}
+
+func readAclEntries(acl SquidAcl) ([]string, error) {
+	data, err := ioutil.ReadFile(SquidSettings.Path.Temp + "/" + acl.Name + ".recv")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var entries []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+
+	return entries, nil
+}
+
+// render normalizes, de-duplicates and sorts the entries, domains already covered by a ".domain" entry are
+// dropped because squid refuses a subdomain of a listed domain
+func (a SquidAcl) render(entries []string) ([]string, error) {
+	unique := make(map[string]bool)
+	for _, entry := range entries {
+		normalized, err := a.normalize(entry)
+		if err != nil {
+			return nil, err
+		}
+		unique[normalized] = true
+	}
+
+	var rendered []string
+	for entry := range unique {
+		if a.isDomain() && coveredDomain(entry, unique) {
+			continue
+		}
+		rendered = append(rendered, entry)
+	}
+	sort.Strings(rendered)
+
+	return rendered, nil
+}
+
+func (a SquidAcl) normalize(entry string) (string, error) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" || strings.ContainsAny(entry, " \t\r\n\"") {
+		return "", errors.New("Invalid entry of acl " + a.Name + ": " + entry)
+	}
+
+	switch {
+	case a.isDomain():
+		entry = strings.TrimSuffix(strings.ToLower(entry), ".")
+		if !aclDomain.MatchString(entry) {
+			return "", errors.New("Invalid domain of acl " + a.Name + ": " + entry)
+		}
+	case a.Type == "src" || a.Type == "dst":
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			return network.String(), nil
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return "", errors.New("Invalid address of acl " + a.Name + ": " + entry)
+		}
+		entry = ip.String()
+	}
+
+	return entry, nil
+}
+
+func (a SquidAcl) isDomain() bool {
+	return a.Type == "dstdomain" || a.Type == "srcdomain"
+}
+
+// coveredDomain reports whether the ".domain" of the entry itself or of a parent is in the set
+func coveredDomain(entry string, domains map[string]bool) bool {
+	if !strings.HasPrefix(entry, ".") && domains["."+entry] {
+		return true
+	}
+	name := strings.TrimPrefix(entry, ".")
+	for {
+		dot := strings.Index(name, ".")
+		if dot < 0 {
+			return false
+		}
+		name = name[dot+1:]
+		if domains["."+name] {
+			return true
+		}
+	}
+}
+
+// parseSquid checks the configuration with the acl files in place
+func parseSquid() error {
+	args := []string{"-k", "parse"}
+	if SquidSettings.Config != "" {
+		args = append(args, "-f", SquidSettings.Config)
+	}
+
+	output, err := runner.Run("/usr/sbin/squid", args...)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"agent/api/command"
+	"agent/api/zfs"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Dataset struct {
+	ZfsPath    string            `json:"zfs_path" form:"zfs_path"`
+	NewZfsPath string            `json:"new_zfs_path"`
+	Properties map[string]string `json:"properties"`
+}
+
+type DatasetUsage struct {
+	Name            string  `json:"name"`
+	Used            int64   `json:"used"`
+	Available       int64   `json:"available"`
+	Referenced      int64   `json:"referenced"`
+	UsedBySnapshots int64   `json:"used_by_snapshots"`
+	CompressRatio   float64 `json:"compress_ratio"`
+	Quota           int64   `json:"quota"`
+	RefQuota        int64   `json:"refquota"`
+	Reservation     int64   `json:"reservation"`
+}
+
+var (
+	runner command.Runner = command.Local{}
+
+	datasetProperties = map[string]bool{
+		"compression":    true,
+		"recordsize":     true,
+		"quota":          true,
+		"refquota":       true,
+		"reservation":    true,
+		"refreservation": true,
+		"atime":          true,
+		"acltype":        true,
+		"xattr":          true,
+	}
+	propertyValue = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+func (d *Dataset) Usage() (DatasetUsage, error) {
+	usage := DatasetUsage{Name: d.ZfsPath}
+
+	properties, err := zfsClient().Get(d.ZfsPath,
+		"used", "available", "referenced", "usedbysnapshots", "compressratio", "quota", "refquota", "reservation")
+	if err != nil {
+		return usage, err
+	}
+
+	for property, value := range properties {
+		switch property {
+		case "used":
+			usage.Used = zfs.ParseSize(value)
+		case "available":
+			usage.Available = zfs.ParseSize(value)
+		case "referenced":
+			usage.Referenced = zfs.ParseSize(value)
+		case "usedbysnapshots":
+			usage.UsedBySnapshots = zfs.ParseSize(value)
+		case "compressratio":
+			usage.CompressRatio, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "quota":
+			usage.Quota = zfs.ParseSize(value)
+		case "refquota":
+			usage.RefQuota = zfs.ParseSize(value)
+		case "reservation":
+			usage.Reservation = zfs.ParseSize(value)
+		}
+	}
+
+	return usage, nil
+}
+
+func (d *Dataset) SetProperties() error {
+	if len(d.Properties) == 0 {
+		return errors.New("No properties to set: " + d.ZfsPath)
+	}
+
+	var properties []string
+	for property, value := range d.Properties {
+		if !datasetProperties[property] {
+			return errors.New("Property is not allowed: " + property)
+		}
+		if !propertyValue.MatchString(value) {
+			return errors.New("Invalid value of property " + property + ": " + value)
+		}
+		properties = append(properties, property+"="+value)
+	}
+	sort.Strings(properties)
+
+	args := append([]string{"set"}, properties...)
+	output, err := runner.Run(zfs.Binary, append(args, d.ZfsPath)...)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+// Rename renames or moves the dataset, missing parents of the new path are created
+func (d *Dataset) Rename() error {
+	if d.NewZfsPath == "" || d.NewZfsPath == d.ZfsPath {
+		return errors.New("Invalid new dataset name: " + d.NewZfsPath)
+	}
+
+	output, err := runner.Run(zfs.Binary, "rename", "-p", d.ZfsPath, d.NewZfsPath)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+func zfsClient() zfs.Client {
+	return zfs.Client{Runner: runner, Binary: zfs.Binary}
+}
@@ -28,23 +28,29 @@ type DhcpMap struct {
 
 var DhcpSettings Dhcp
 
-func (d *DhcpString) Download() error {
+// Download replaces the config, false is returned when the assembled config is the same as prod
+func (d *DhcpString) Download() (bool, error) {
 	return crud(d.Data["dhcpd"], true)
 }
 
 func (d *DhcpString) Create() error {
-	return crud(d.Data["dhcpd"], false)
+	_, err := crud(d.Data["dhcpd"], false)
+	return err
 }
 
 func (d *DhcpMap) Update() error {
-	return crud(d.Data["dhcpd"], false)
+	_, err := crud(d.Data["dhcpd"], false)
+	return err
 }
 
 func (d *DhcpSlice) Delete() error {
-	return crud(d.Data["dhcpd"], false)
+	_, err := crud(d.Data["dhcpd"], false)
+	return err
 }
 
-func crud(line interface{}, download bool) error {
+// crud changes the recv and installs the assembled config, prod is left alone and dhcpd is not restarted
+// when the config did not change
+func crud(line interface{}, download bool) (bool, error) {
 	temp := DhcpSettings.Path.Temp
 	head := temp + "/dhcpd.conf.head"
 	recv := temp + "/dhcpd.conf.recv"
@@ -53,9 +59,9 @@ func crud(line interface{}, download bool) error {
 
 	if err := beginTransaction(recv, backup); err != nil {
 		if err = rollback(recv, backup); err != nil {
-			return err
+			return false, err
 		}
-		return err
+		return false, err
 	}
 
 	switch line.(type) {
@@ -63,39 +69,39 @@ func crud(line interface{}, download bool) error {
 		if download {
 			if err := ioutil.WriteFile(recv, []byte(line.(string)), 0644); err != nil {
 				if err = rollback(recv, backup); err != nil {
-					return err
+					return false, err
 				}
-				return err
+				return false, err
 			}
 		} else {
 			if err := add(recv, line.(string)); err != nil {
 				if err = rollback(recv, backup); err != nil {
-					return err
+					return false, err
 				}
-				return err
+				return false, err
 			}
 		}
 	case map[string]string:
 		if err := update(recv, line.(map[string]string)); err != nil {
 			if err = rollback(recv, backup); err != nil {
-				return err
+				return false, err
 			}
-			return err
+			return false, err
 		}
 	case []string:
 		if err := remove(recv, line.([]string)); err != nil {
 			if err = rollback(recv, backup); err != nil {
-				return err
+				return false, err
 			}
-			return err
+			return false, err
 		}
 	}
 
 	if err := commit(head, recv, conf); err != nil {
 		if err = rollback(recv, backup); err != nil {
-			return err
+			return false, err
 		}
-		return err
+		return false, err
 	}
 
 	//if err := testConfig(tempConf); err != nil {
@@ -105,30 +111,22 @@ func crud(line interface{}, download bool) error {
 	//	return err
 	//}
 
-	data, err := ioutil.ReadFile(conf)
-	if err != nil {
-		if err = rollback(recv, backup); err != nil {
-			return err
-		}
-		return err
+	prod := DhcpSettings.Path.Prod + "/dhcpd.conf"
+	same, err := sameChecksum(conf, prod)
+	if err == nil && same {
+		return false, nil
 	}
-
-	conf = DhcpSettings.Path.Prod + "/dhcpd.conf"
-	if err = ioutil.WriteFile(conf, data, 0644); err != nil {
-		if err = rollback(recv, backup); err != nil {
-			return err
-		}
-		return err
+	if err == nil {
+		err = install(map[string]string{prod: conf}, restartDhcp)
 	}
-
-	if err = restartDhcp(); err != nil {
-		if err = rollback(recv, backup); err != nil {
-			return err
+	if err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return false, rollbackErr
 		}
-		return err
+		return false, err
 	}
 
-	return nil
+	return true, nil
 }
 
 //func testConfig(conf string) error {
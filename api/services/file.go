@@ -1,10 +1,13 @@
 package services
 
 import (
+	"crypto/sha256"
 	"io"
 	"io/ioutil"
 	"os"
 	"regexp"
+	"sort"
+	"strings"
 )
 
 func beginTransaction(recv string, backup string) error {
@@ -51,6 +54,166 @@ func rollback(recv string, backup string) error {
 	return nil
 }
 
+// fileBackup is a copy of a file taken before a change, a file that did not exist is removed on restore
+type fileBackup struct {
+	path    string
+	backup  string
+	existed bool
+}
+
+func backupFile(path string, backup string) (fileBackup, error) {
+	saved := fileBackup{path: path, backup: backup}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return saved, nil
+	}
+	if err != nil {
+		return saved, err
+	}
+	if err = ioutil.WriteFile(backup, data, 0644); err != nil {
+		return saved, err
+	}
+	saved.existed = true
+
+	return saved, nil
+}
+
+func (b fileBackup) restore() error {
+	if !b.existed {
+		if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(b.backup)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(b.path, data, 0644)
+}
+
+// fileBackups are the files saved before a change of several files, they are restored in reverse order
+type fileBackups []fileBackup
+
+// backupFiles saves every path of the map to its backup, the files already saved are restored when one fails
+func backupFiles(files map[string]string) (fileBackups, error) {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var backups fileBackups
+	for _, path := range paths {
+		saved, err := backupFile(path, files[path])
+		if err != nil {
+			return nil, backups.restore(err)
+		}
+		backups = append(backups, saved)
+	}
+
+	return backups, nil
+}
+
+// restore restores every file and returns err, or the error of the restore that failed
+func (b fileBackups) restore(err error) error {
+	for i := len(b) - 1; i >= 0; i-- {
+		if restoreErr := b[i].restore(); restoreErr != nil {
+			return restoreErr
+		}
+	}
+
+	return err
+}
+
+// install copies every assembled file over its prod file, the map is keyed by prod, and runs apply; the prod
+// files are restored and apply is run again for them when a copy or apply fails, so a retry is not taken for
+// an unchanged config
+func install(files map[string]string, apply func() error) error {
+	saves := make(map[string]string)
+	for prod, conf := range files {
+		saves[prod] = conf + ".prod.backup"
+	}
+	backups, err := backupFiles(saves)
+	if err != nil {
+		return err
+	}
+
+	for prod, conf := range files {
+		data, err := ioutil.ReadFile(conf)
+		if err != nil {
+			return backups.restore(err)
+		}
+		if err = ioutil.WriteFile(prod, data, 0644); err != nil {
+			return backups.restore(err)
+		}
+	}
+
+	if err = apply(); err != nil {
+		if restoreErr := backups.restore(err); restoreErr != err {
+			return restoreErr
+		}
+		_ = apply()
+		return err
+	}
+
+	return nil
+}
+
+// tailFile reads the last bytes of the file from the start of a line
+func tailFile(path string, tail int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - tail
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	text := string(data)
+	if offset > 0 {
+		if start := strings.IndexByte(text, '\n'); start >= 0 {
+			text = text[start+1:]
+		}
+	}
+
+	return text, nil
+}
+
+// sameChecksum reports whether the assembled file and prod have the same sha256, a missing prod is never the same
+func sameChecksum(conf string, prod string) (bool, error) {
+	confData, err := ioutil.ReadFile(conf)
+	if err != nil {
+		return false, err
+	}
+	prodData, err := ioutil.ReadFile(prod)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return sha256.Sum256(confData) == sha256.Sum256(prodData), nil
+}
+
 func commit(head string, recv string, conf string) error {
 	if _, err := os.OpenFile(head, os.O_RDONLY|os.O_CREATE, 0644); err != nil {
 		return err
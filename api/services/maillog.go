@@ -0,0 +1,85 @@
+package services
+
+import (
+	"agent/api/mail"
+	"errors"
+	"strings"
+	"time"
+)
+
+// MailLogSearch filters the delivery events of the mail log, Since and Until are RFC 3339 times
+type MailLogSearch struct {
+	Address string `json:"address" form:"address"`
+	Status  string `json:"status" form:"status"`
+	Since   string `json:"since" form:"since"`
+	Until   string `json:"until" form:"until"`
+	Limit   int    `json:"limit" form:"limit"`
+}
+
+const (
+	mailLogTailBytes = 16 * 1024 * 1024
+	mailLogLimit     = 500
+)
+
+// Search returns the matching events of the tail of the mail log, the newest first
+func (m *MailLogSearch) Search() ([]mail.Event, error) {
+	var since, until time.Time
+	var err error
+	if m.Since != "" {
+		if since, err = time.Parse(time.RFC3339, m.Since); err != nil {
+			return nil, err
+		}
+	}
+	if m.Until != "" {
+		if until, err = time.Parse(time.RFC3339, m.Until); err != nil {
+			return nil, err
+		}
+	}
+	limit := m.Limit
+	if limit <= 0 {
+		limit = mailLogLimit
+	}
+
+	text, err := tailMailLog()
+	if err != nil {
+		return nil, err
+	}
+	events := mail.ParseLog(text, time.Now())
+
+	address := strings.ToLower(m.Address)
+	matched := []mail.Event{}
+	for i := len(events) - 1; i >= 0 && len(matched) < limit; i-- {
+		event := events[i]
+		if !since.IsZero() && event.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && event.Time.After(until) {
+			continue
+		}
+		if m.Status != "" && !strings.EqualFold(event.Status, m.Status) {
+			continue
+		}
+		if address != "" &&
+			!strings.Contains(strings.ToLower(event.From), address) &&
+			!strings.Contains(strings.ToLower(event.To), address) &&
+			!strings.Contains(strings.ToLower(event.OrigTo), address) {
+			continue
+		}
+		matched = append(matched, event)
+	}
+
+	return matched, nil
+}
+
+func tailMailLog() (string, error) {
+	if SmtpSettings.Log.Path == "" {
+		return "", errors.New("Mail log path is not configured")
+	}
+
+	tail := SmtpSettings.Log.TailBytes
+	if tail <= 0 {
+		tail = mailLogTailBytes
+	}
+
+	return tailFile(SmtpSettings.Log.Path, tail)
+}
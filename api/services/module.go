@@ -0,0 +1,373 @@
+package services
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	HeadPrepend = "prepend"
+	HeadAppend  = "append"
+	HeadNone    = "none"
+)
+
+// Module is a set of files assembled from name.head and name.recv in temp and promoted to prod, declared in agent.yml
+// with the files it accepts and the commands to validate and apply them
+type Module struct {
+	Name string
+	Path struct {
+		Prod string
+		Temp string
+	}
+	Head     string
+	Owner    string
+	Mode     string
+	Validate []string
+	Apply    []string
+	Files    []FileRule
+	// anyFile accepts files that are not declared in Files, without commands
+	anyFile bool
+}
+
+// FileRule declares a file of a module, the commands are split on blanks and run without a shell,
+// "{file}" is replaced with the assembled file and "{name}" with the file name
+type FileRule struct {
+	Name     string
+	Validate []string
+	Apply    []string
+}
+
+type ModuleString struct {
+	Data map[string]interface{}
+}
+
+type ModuleFile struct {
+	Name string  `json:"name" form:"name"`
+	Data *string `json:"data"`
+	Head string  `json:"head"`
+	Recv string  `json:"recv"`
+	Prod string  `json:"prod"`
+}
+
+var (
+	ModuleSettings []Module
+
+	moduleName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+	// builtinModules are the route groups of the agent itself
+	builtinModules = map[string]bool{"dhcp": true, "smtp": true, "squid": true, "techmail": true, "samba": true, "nfs": true}
+)
+
+func (m Module) Check() error {
+	if !moduleName.MatchString(m.Name) || builtinModules[m.Name] {
+		return errors.New("Invalid module name: " + m.Name)
+	}
+	if m.Path.Prod == "" || m.Path.Temp == "" {
+		return errors.New("Module paths are not configured: " + m.Name)
+	}
+	switch m.Head {
+	case "", HeadPrepend, HeadAppend, HeadNone:
+	default:
+		return errors.New("Invalid head handling of module " + m.Name + ": " + m.Head)
+	}
+	if m.Mode != "" {
+		if _, err := strconv.ParseUint(m.Mode, 8, 32); err != nil {
+			return errors.New("Invalid mode of module " + m.Name + ": " + m.Mode)
+		}
+	}
+	for _, rule := range m.Files {
+		if rule.Name == "" || rule.Name == "." || rule.Name == ".." || strings.ContainsAny(rule.Name, "/\\\x00") {
+			return errors.New("Invalid file name of module " + m.Name + ": " + rule.Name)
+		}
+	}
+
+	return nil
+}
+
+// Download replaces the files of the request
+func (m Module) Download(s *ModuleString) error {
+	files := make(map[string]string)
+	for name, line := range s.Data {
+		text, ok := line.(string)
+		if !ok {
+			return errors.New("Invalid " + m.Name + " file: " + name)
+		}
+		files[name] = text
+	}
+
+	return m.commit(files)
+}
+
+// Read returns the head, recv and promoted content of the file
+func (m Module) Read(name string) (ModuleFile, error) {
+	file := ModuleFile{Name: name}
+	if _, err := m.rule(name); err != nil {
+		return file, err
+	}
+
+	var err error
+	if file.Head, err = readOptional(m.Path.Temp + "/" + name + ".head"); err != nil {
+		return file, err
+	}
+	if file.Recv, err = readOptional(m.Path.Temp + "/" + name + ".recv"); err != nil {
+		return file, err
+	}
+	if file.Prod, err = readOptional(m.Path.Prod + "/" + name); err != nil {
+		return file, err
+	}
+
+	return file, nil
+}
+
+// Diff compares the promoted file with the file assembled from data, or with its previous version without data
+func (m Module) Diff(name string, data *string) (string, error) {
+	if _, err := m.rule(name); err != nil {
+		return "", err
+	}
+
+	old := m.Path.Temp + "/" + name + ".prod.backup"
+	current := m.Path.Prod + "/" + name
+	if data != nil {
+		recv := m.Path.Temp + "/" + name + ".diff.recv"
+		staged := m.Path.Temp + "/" + name + ".diff"
+		defer os.Remove(recv)
+		defer os.Remove(staged)
+		if err := ioutil.WriteFile(recv, []byte(*data), 0644); err != nil {
+			return "", err
+		}
+		if err := m.assemble(name, recv, staged); err != nil {
+			return "", err
+		}
+		old, current = current, staged
+	}
+	if _, err := os.Stat(old); os.IsNotExist(err) {
+		old = os.DevNull
+	}
+	if _, err := os.Stat(current); os.IsNotExist(err) {
+		current = os.DevNull
+	}
+
+	output, err := runner.Run("/usr/bin/diff", "-u", "--label", "old/"+name, "--label", "new/"+name, old, current)
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return "", errors.New(err.Error() + ": " + string(output))
+	}
+
+	return string(output), nil
+}
+
+// Rollback commits the previous recv of the file again, a second rollback returns to the current version
+func (m Module) Rollback(name string) error {
+	if _, err := m.rule(name); err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(m.Path.Temp + "/" + name + ".recv.backup")
+	if os.IsNotExist(err) {
+		return errors.New("No previous version of " + m.Name + " file: " + name)
+	}
+	if err != nil {
+		return err
+	}
+
+	return m.commit(map[string]string{name: string(data)})
+}
+
+func (m Module) rule(name string) (FileRule, error) {
+	for _, rule := range m.Files {
+		if rule.Name == name {
+			return rule, nil
+		}
+	}
+
+	if m.anyFile && name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00") {
+		return FileRule{Name: name}, nil
+	}
+
+	return FileRule{}, errors.New("File is not allowed in " + m.Name + ": " + name)
+}
+
+// assemble writes the head and the recv of the file to conf in the order of the head handling of the module
+func (m Module) assemble(name string, recv string, conf string) error {
+	head := m.Path.Temp + "/" + name + ".head"
+	switch m.Head {
+	case HeadNone:
+		data, err := ioutil.ReadFile(recv)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(conf, data, 0644)
+	case HeadAppend:
+		if _, err := os.OpenFile(head, os.O_RDONLY|os.O_CREATE, 0644); err != nil {
+			return err
+		}
+		return commit(recv, head, conf)
+	}
+
+	return commit(head, recv, conf)
+}
+
+// commit writes the recv of every file and assembles it in temp, runs the validate commands on the assembled
+// files, promotes them together and runs each distinct apply command once; every recv and prod file is
+// restored on failure and the apply commands are run again for the restored files
+func (m Module) commit(files map[string]string) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if _, err := m.rule(name); err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	saves := make(map[string]string)
+	for _, name := range names {
+		saves[m.Path.Temp+"/"+name+".recv"] = m.Path.Temp + "/" + name + ".recv.saved"
+		saves[m.Path.Prod+"/"+name] = m.Path.Temp + "/" + name + ".prod.saved"
+	}
+	backups, err := backupFiles(saves)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, name := range names {
+			_ = os.Remove(m.Path.Temp + "/" + name + ".staged")
+		}
+	}()
+
+	for _, name := range names {
+		recv := m.Path.Temp + "/" + name + ".recv"
+		staged := m.Path.Temp + "/" + name + ".staged"
+		if err := ioutil.WriteFile(recv, []byte(files[name]), 0644); err != nil {
+			return backups.restore(err)
+		}
+		if err := m.assemble(name, recv, staged); err != nil {
+			return backups.restore(err)
+		}
+		for _, command := range m.commands(name, false) {
+			if err := runRule(command, name, staged); err != nil {
+				return backups.restore(err)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if err := m.promote(name); err != nil {
+			return backups.restore(err)
+		}
+	}
+
+	if err := m.apply(names); err != nil {
+		if restoreErr := backups.restore(err); restoreErr != err {
+			return restoreErr
+		}
+		_ = m.apply(names)
+		return err
+	}
+
+	// the files saved before a successful commit are the previous version used by Diff and Rollback
+	for _, saved := range backups {
+		previous := strings.TrimSuffix(saved.backup, ".saved") + ".backup"
+		if !saved.existed {
+			_ = os.Remove(previous)
+			continue
+		}
+		if err := os.Rename(saved.backup, previous); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// promote moves the assembled file to prod with the owner and mode of the module
+func (m Module) promote(name string) error {
+	staged := m.Path.Temp + "/" + name + ".staged"
+	conf := m.Path.Prod + "/" + name
+
+	data, err := ioutil.ReadFile(staged)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(conf, data, 0644); err != nil {
+		return err
+	}
+	_ = os.Remove(staged)
+
+	if m.Mode != "" {
+		mode, _ := strconv.ParseUint(m.Mode, 8, 32)
+		if err = os.Chmod(conf, os.FileMode(mode)); err != nil {
+			return err
+		}
+	}
+	if m.Owner != "" {
+		output, err := runner.Run("/usr/bin/chown", m.Owner, conf)
+		if err != nil {
+			return errors.New(err.Error() + ": " + string(output) + " - Owner: " + conf)
+		}
+	}
+
+	return nil
+}
+
+// apply runs the apply commands of the files, a command shared by several files runs once
+func (m Module) apply(names []string) error {
+	done := make(map[string]bool)
+	for _, name := range names {
+		conf := m.Path.Prod + "/" + name
+		for _, command := range m.commands(name, true) {
+			expanded := expandRule(command, name, conf)
+			if done[expanded] {
+				continue
+			}
+			done[expanded] = true
+			if err := runRule(command, name, conf); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// commands returns the validate or apply commands of the module followed by the ones of the file
+func (m Module) commands(name string, apply bool) []string {
+	rule, _ := m.rule(name)
+	if apply {
+		return append(append([]string(nil), m.Apply...), rule.Apply...)
+	}
+
+	return append(append([]string(nil), m.Validate...), rule.Validate...)
+}
+
+func expandRule(command string, name string, file string) string {
+	return strings.NewReplacer("{file}", file, "{name}", name).Replace(command)
+}
+
+func runRule(command string, name string, file string) error {
+	args := strings.Fields(expandRule(command, name, file))
+	if len(args) == 0 {
+		return nil
+	}
+
+	output, err := runner.Run(args[0], args[1:]...)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output) + " - Command: " + strings.Join(args, " "))
+	}
+
+	return nil
+}
+
+func readOptional(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	return string(data), nil
+}
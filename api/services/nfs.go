@@ -0,0 +1,330 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Nfs struct {
+	Enabled bool
+	File    string
+	Path    struct {
+		Prod string
+		Temp string
+	}
+}
+
+type NfsString struct {
+	Data map[string]interface{}
+}
+
+type NfsExport struct {
+	Path    string      `json:"path"`
+	Clients []NfsClient `json:"clients"`
+}
+
+type NfsClient struct {
+	Host    string   `json:"host"`
+	Options []string `json:"options"`
+}
+
+var (
+	NfsSettings Nfs
+
+	nfsHost   = regexp.MustCompile(`^[A-Za-z0-9*?.:/@_\[\]][A-Za-z0-9*?.:/@_\[\]-]*$`)
+	nfsOption = regexp.MustCompile(`^[a-z_]+(=[A-Za-z0-9:/._@-]+)?$`)
+
+	// nfsOptions are the export options of exports(5), true for those taking a value
+	nfsOptions = map[string]bool{
+		"rw": false, "ro": false, "sync": false, "async": false, "secure": false, "insecure": false,
+		"wdelay": false, "no_wdelay": false, "hide": false, "nohide": false, "crossmnt": false,
+		"subtree_check": false, "no_subtree_check": false, "secure_locks": false, "insecure_locks": false,
+		"auth_nlm": false, "no_auth_nlm": false, "root_squash": false, "no_root_squash": false,
+		"all_squash": false, "no_all_squash": false, "acl": false, "no_acl": false, "nordirplus": false,
+		"pnfs": false, "no_pnfs": false, "security_label": false, "mountpoint": false, "mp": false,
+		"fsid": true, "anonuid": true, "anongid": true, "sec": true, "refer": true, "replicas": true,
+	}
+)
+
+func (n *NfsString) Download() error {
+	line, ok := n.Data["exports"].(string)
+	if !ok {
+		return errors.New("Exports are empty")
+	}
+
+	exports, err := parseExports(line)
+	if err != nil {
+		return err
+	}
+
+	return changeExports(func(current map[string]NfsExport) error {
+		for path := range current {
+			delete(current, path)
+		}
+		for _, export := range exports {
+			current[export.Path] = export
+		}
+		return nil
+	})
+}
+
+func (e *NfsExport) Create() error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	return changeExports(func(current map[string]NfsExport) error {
+		if _, ok := current[e.Path]; ok {
+			return errors.New("Duplicate export path: " + e.Path)
+		}
+		current[e.Path] = *e
+		return nil
+	})
+}
+
+func (e *NfsExport) Update() error {
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	return changeExports(func(current map[string]NfsExport) error {
+		if _, ok := current[e.Path]; !ok {
+			return errors.New("Export doesn't exists: " + e.Path)
+		}
+		current[e.Path] = *e
+		return nil
+	})
+}
+
+func (e *NfsExport) Delete() error {
+	return changeExports(func(current map[string]NfsExport) error {
+		if _, ok := current[e.Path]; !ok {
+			return errors.New("Export doesn't exists: " + e.Path)
+		}
+		delete(current, e.Path)
+		return nil
+	})
+}
+
+func NfsExports() ([]NfsExport, error) {
+	recvData, err := ioutil.ReadFile(NfsSettings.Path.Temp + "/exports.recv")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	exports, err := parseExports(string(recvData))
+	if err != nil {
+		return nil, err
+	}
+	if exports == nil {
+		exports = []NfsExport{}
+	}
+
+	return exports, nil
+}
+
+func (e *NfsExport) validate() error {
+	if !filepath.IsAbs(e.Path) || strings.ContainsAny(e.Path, "\n\"") {
+		return errors.New("Invalid export path: " + e.Path)
+	}
+	if len(e.Clients) == 0 {
+		return errors.New("Export has no clients: " + e.Path)
+	}
+	for _, client := range e.Clients {
+		if !nfsHost.MatchString(client.Host) {
+			return errors.New("Invalid export client: " + client.Host)
+		}
+		for _, option := range client.Options {
+			if !nfsOption.MatchString(option) {
+				return errors.New("Invalid export option: " + option)
+			}
+			name, value, found := strings.Cut(option, "=")
+			hasValue, known := nfsOptions[name]
+			if name == "mountpoint" || name == "mp" {
+				hasValue = found
+			}
+			if !known || hasValue != found || (found && value == "") {
+				return errors.New("Invalid export option: " + option)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateExports checks every export of the assembled file before it is installed, exportfs -ra only
+// reports a broken line once it is live
+func validateExports(conf string) error {
+	confData, err := ioutil.ReadFile(conf)
+	if err != nil {
+		return err
+	}
+
+	exports, err := parseExports(string(confData))
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		if err = export.validate(); err != nil {
+			return err
+		}
+		info, err := os.Stat(export.Path)
+		if err != nil {
+			return errors.New(err.Error() + " - Export: " + export.Path)
+		}
+		if !info.IsDir() {
+			return errors.New("Export path is not a directory: " + export.Path)
+		}
+	}
+
+	return nil
+}
+
+func (e *NfsExport) render() string {
+	var clients []string
+	for _, client := range e.Clients {
+		clients = append(clients, client.Host+"("+strings.Join(client.Options, ",")+")")
+	}
+
+	return strings.ReplaceAll(e.Path, " ", `\040`) + " " + strings.Join(clients, " ") + "\n"
+}
+
+// changeExports applies the change to exports.recv, validates the assembled file, installs it and reloads the exports
+func changeExports(change func(current map[string]NfsExport) error) error {
+	temp := NfsSettings.Path.Temp
+	head := temp + "/exports.head"
+	recv := temp + "/exports.recv"
+	backup := temp + "/exports.recv.backup"
+	conf := temp + "/exports"
+	prod := NfsSettings.Path.Prod + "/" + nfsFile()
+
+	exports, err := NfsExports()
+	if err != nil {
+		return err
+	}
+	current := make(map[string]NfsExport)
+	for _, export := range exports {
+		current[export.Path] = export
+	}
+	if err = change(current); err != nil {
+		return err
+	}
+	for _, export := range current {
+		if err = export.validate(); err != nil {
+			return err
+		}
+	}
+
+	paths := make([]string, 0, len(current))
+	for path := range current {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var rendered strings.Builder
+	for _, path := range paths {
+		export := current[path]
+		rendered.WriteString(export.render())
+	}
+
+	if err = beginTransaction(recv, backup); err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err = ioutil.WriteFile(recv, []byte(rendered.String()), 0644); err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err = commit(head, recv, conf); err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err = validateExports(conf); err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	if err = install(map[string]string{prod: conf}, exportfs); err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return nil
+}
+
+// parseExports parses exports(5) lines, a host without options gets an empty option list
+func parseExports(text string) ([]NfsExport, error) {
+	var exports []NfsExport
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	line := ""
+	for scanner.Scan() {
+		part := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(part, "\\") {
+			line += strings.TrimSuffix(part, "\\") + " "
+			continue
+		}
+		line += part
+
+		current := strings.TrimSpace(line)
+		line = ""
+		if current == "" || strings.HasPrefix(current, "#") {
+			continue
+		}
+
+		fields := strings.Fields(current)
+		export := NfsExport{Path: strings.ReplaceAll(fields[0], `\040`, " ")}
+		for _, field := range fields[1:] {
+			host, options, found := strings.Cut(field, "(")
+			client := NfsClient{Host: host, Options: []string{}}
+			if found {
+				options = strings.TrimSuffix(options, ")")
+				if options != "" {
+					client.Options = strings.Split(options, ",")
+				}
+			}
+			export.Clients = append(export.Clients, client)
+		}
+		exports = append(exports, export)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return exports, nil
+}
+
+func nfsFile() string {
+	if NfsSettings.File != "" {
+		return NfsSettings.File
+	}
+
+	return "exports"
+}
+
+func exportfs() error {
+	output, err := runner.Run("/usr/sbin/exportfs", "-ra")
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	AclPosix    = "posix"
+	AclNfs4     = "nfs4"
+	DefaultMode = "0770"
+)
+
+type Permissions struct {
+	Path      string     `json:"path" form:"path"`
+	Owner     string     `json:"owner"`
+	Group     string     `json:"group"`
+	Mode      string     `json:"mode"`
+	AclType   string     `json:"acl_type" form:"acl_type"`
+	Acl       []AclEntry `json:"acl"`
+	Recursive bool       `json:"recursive"`
+}
+
+// AclEntry is a posix entry (default:type:name:perms) or an nfs4 ace (type:flags:name:perms)
+type AclEntry struct {
+	Type      string `json:"type"`
+	Flags     string `json:"flags,omitempty"`
+	Name      string `json:"name"`
+	Perms     string `json:"perms"`
+	Default   bool   `json:"default,omitempty"`
+	Effective string `json:"effective,omitempty"`
+}
+
+var (
+	modePattern     = regexp.MustCompile(`^[0-7]{3,4}$`)
+	aclFieldPattern = regexp.MustCompile(`^[A-Za-z0-9@._\\ -]*$`)
+)
+
+func sharePermissions(data map[string]interface{}) (Permissions, error) {
+	var permissions Permissions
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return permissions, err
+	}
+	if err = json.Unmarshal(encoded, &permissions); err != nil {
+		return permissions, err
+	}
+
+	if permissions.Mode == "" {
+		permissions.Mode = ShareSettings.Mode
+	}
+	if permissions.Mode == "" {
+		permissions.Mode = DefaultMode
+	}
+
+	return permissions, nil
+}
+
+func (p *Permissions) Apply() error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+
+	if p.Owner != "" || p.Group != "" {
+		owner := p.Owner
+		if p.Group != "" {
+			owner += ":" + p.Group
+		}
+		if err := p.run("/usr/bin/chown", owner, p.Path); err != nil {
+			return err
+		}
+	}
+
+	if p.Mode != "" {
+		if err := p.run("/usr/bin/chmod", p.Mode, p.Path); err != nil {
+			return err
+		}
+	}
+
+	switch p.AclType {
+	case AclPosix:
+		if err := p.run("/usr/bin/setfacl", "-b", p.Path); err != nil {
+			return err
+		}
+		if len(p.Acl) > 0 {
+			var entries []string
+			for _, entry := range p.Acl {
+				entries = append(entries, entry.posix())
+			}
+			if err := p.run("/usr/bin/setfacl", "-m", strings.Join(entries, ","), p.Path); err != nil {
+				return err
+			}
+		}
+	case AclNfs4:
+		if len(p.Acl) > 0 {
+			var entries []string
+			for _, entry := range p.Acl {
+				entries = append(entries, entry.Type+":"+entry.Flags+":"+entry.Name+":"+entry.Perms)
+			}
+			if err := p.run("/usr/bin/nfs4_setfacl", "-s", strings.Join(entries, ","), p.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Permissions) validate() error {
+	if p.Path == "" {
+		return errors.New("Path is empty")
+	}
+	if p.Mode != "" && !modePattern.MatchString(p.Mode) {
+		return errors.New("Invalid mode: " + p.Mode)
+	}
+	if p.AclType != "" && p.AclType != AclPosix && p.AclType != AclNfs4 {
+		return errors.New("Unknown acl type: " + p.AclType)
+	}
+	if p.AclType == "" && len(p.Acl) > 0 {
+		return errors.New("Acl type is empty")
+	}
+	for _, entry := range p.Acl {
+		for _, field := range []string{entry.Type, entry.Flags, entry.Name, entry.Perms} {
+			if !aclFieldPattern.MatchString(field) {
+				return errors.New("Invalid acl entry field: " + field)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Read reports the owner, mode and acl of the path, posix entries carry the permissions left after the mask
+func (p *Permissions) Read() (Permissions, error) {
+	permissions := Permissions{Path: p.Path, AclType: p.AclType}
+	if permissions.AclType == "" {
+		permissions.AclType = AclPosix
+	}
+
+	info, err := os.Stat(p.Path)
+	if err != nil {
+		return permissions, err
+	}
+	permissions.Mode = "0" + strconv.FormatUint(uint64(info.Mode().Perm()), 8)
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		uid := strconv.FormatUint(uint64(stat.Uid), 10)
+		gid := strconv.FormatUint(uint64(stat.Gid), 10)
+		permissions.Owner, permissions.Group = uid, gid
+		if owner, err := user.LookupId(uid); err == nil {
+			permissions.Owner = owner.Username
+		}
+		if group, err := user.LookupGroupId(gid); err == nil {
+			permissions.Group = group.Name
+		}
+	}
+
+	switch permissions.AclType {
+	case AclPosix:
+		output, err := runner.Run("/usr/bin/getfacl", "-cp", p.Path)
+		if err != nil {
+			return permissions, errors.New(err.Error() + ": " + string(output))
+		}
+		permissions.Acl = parsePosixAcl(string(output))
+	case AclNfs4:
+		output, err := runner.Run("/usr/bin/nfs4_getfacl", p.Path)
+		if err != nil {
+			return permissions, errors.New(err.Error() + ": " + string(output))
+		}
+		permissions.Acl = parseNfs4Acl(string(output))
+	default:
+		return permissions, errors.New("Unknown acl type: " + permissions.AclType)
+	}
+
+	return permissions, nil
+}
+
+func (p *Permissions) run(name string, args ...string) error {
+	if p.Recursive {
+		args = append([]string{"-R"}, args...)
+	}
+
+	output, err := runner.Run(name, args...)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+func (e AclEntry) posix() string {
+	entry := e.Type + ":" + e.Name + ":" + e.Perms
+	if e.Default {
+		entry = "default:" + entry
+	}
+
+	return entry
+}
+
+// parsePosixAcl parses getfacl -cp output such as "user:alice:rwx	#effective:r-x"
+func parsePosixAcl(output string) []AclEntry {
+	var entries []AclEntry
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		effective := ""
+		if rule, comment, found := strings.Cut(line, "#effective:"); found {
+			line = strings.TrimSpace(rule)
+			effective = strings.TrimSpace(comment)
+		}
+
+		entry := AclEntry{}
+		if strings.HasPrefix(line, "default:") {
+			entry.Default = true
+			line = strings.TrimPrefix(line, "default:")
+		}
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		entry.Type, entry.Name, entry.Perms = fields[0], fields[1], fields[2]
+		entry.Effective = entry.Perms
+		if effective != "" {
+			entry.Effective = effective
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// parseNfs4Acl parses nfs4_getfacl output, one "type:flags:principal:permissions" ace per line
+func parseNfs4Acl(output string) []AclEntry {
+	var entries []AclEntry
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		entries = append(entries, AclEntry{Type: fields[0], Flags: fields[1], Name: fields[2], Perms: fields[3], Effective: fields[3]})
+	}
+
+	return entries
+}
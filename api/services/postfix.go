@@ -0,0 +1,165 @@
+package services
+
+import (
+	"agent/api/mail"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+)
+
+type PostfixMap struct {
+	Map   string `json:"map" form:"map"`
+	Data  string `json:"data"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (p *PostfixMap) Entries() ([]*mail.Entry, error) {
+	if err := validMapName(p.Map); err != nil {
+		return nil, err
+	}
+
+	lookup, err := readMap(p.Map)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookup.Entries, nil
+}
+
+// Download replaces the entries of the map with the entries of the data
+func (p *PostfixMap) Download() error {
+	entries, err := mail.ParseMapEntries(p.Data)
+	if err != nil {
+		return err
+	}
+
+	return changeMap(p.Map, func(lookup *mail.Map) error {
+		lookup.Entries = entries
+		return nil
+	})
+}
+
+func (p *PostfixMap) Create() error {
+	return changeMap(p.Map, func(lookup *mail.Map) error {
+		return lookup.Add(&mail.Entry{Key: p.Key, Value: p.Value})
+	})
+}
+
+func (p *PostfixMap) Update() error {
+	return changeMap(p.Map, func(lookup *mail.Map) error {
+		return lookup.Update(&mail.Entry{Key: p.Key, Value: p.Value})
+	})
+}
+
+func (p *PostfixMap) Delete() error {
+	return changeMap(p.Map, func(lookup *mail.Map) error {
+		return lookup.Delete(p.Key)
+	})
+}
+
+func readMap(name string) (*mail.Map, error) {
+	temp := SmtpSettings.Path.Temp
+	headData, err := ioutil.ReadFile(temp + "/" + name + ".head")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	recvData, err := ioutil.ReadFile(temp + "/" + name + ".recv")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return mail.ParseMap(string(headData), string(recvData))
+}
+
+// changeMap applies the change to the parsed map recv, installs the rendered map, compiles it and reloads postfix,
+// the previous map is installed and compiled again when postmap or the reload fails
+func changeMap(name string, change func(lookup *mail.Map) error) error {
+	if err := validMapName(name); err != nil {
+		return err
+	}
+
+	temp := SmtpSettings.Path.Temp
+	head := temp + "/" + name + ".head"
+	recv := temp + "/" + name + ".recv"
+	backup := recv + ".backup"
+	staged := temp + "/" + name + ".staged"
+	conf := SmtpSettings.Maps.Path + "/" + name
+
+	if err := beginTransaction(recv, backup); err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	lookup, err := readMap(name)
+	if err != nil {
+		return err
+	}
+	if err = change(lookup); err != nil {
+		return err
+	}
+	if err = lookup.Validate(); err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(recv, []byte(lookup.Render()), 0644)
+	if err == nil {
+		err = commit(head, recv, staged)
+	}
+	if err == nil {
+		err = install(map[string]string{conf: staged}, func() error {
+			if err := postmap(conf); err != nil {
+				return err
+			}
+			return reloadPostfix()
+		})
+	}
+	_ = os.Remove(staged)
+	if err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return nil
+}
+
+func validMapName(name string) error {
+	for _, allowed := range SmtpSettings.Maps.Names {
+		if name == allowed {
+			return nil
+		}
+	}
+
+	return errors.New("Unknown postfix map: " + name)
+}
+
+// postmap compiles the lookup table into the database postfix reads
+func postmap(conf string) error {
+	mapType := SmtpSettings.Maps.Type
+	if mapType == "" {
+		mapType = "hash"
+	}
+
+	cli := exec.Command("/usr/sbin/postmap", mapType+":"+conf)
+	output, err := cli.CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output) + " - Map: " + conf)
+	}
+
+	return nil
+}
+
+func reloadPostfix() error {
+	cli := exec.Command("/usr/sbin/postfix", "reload")
+	output, err := cli.CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
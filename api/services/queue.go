@@ -0,0 +1,185 @@
+package services
+
+import (
+	"agent/api/mail"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MailQueue selects queued messages by id or by sender and recipient patterns, the patterns are case-insensitive regexps
+type MailQueue struct {
+	Id        string   `json:"id" form:"id"`
+	Ids       []string `json:"ids"`
+	Sender    string   `json:"sender" form:"sender"`
+	Recipient string   `json:"recipient" form:"recipient"`
+}
+
+const (
+	postqueue = "/usr/sbin/postqueue"
+	postsuper = "/usr/sbin/postsuper"
+	postcat   = "/usr/sbin/postcat"
+	mailq     = "/usr/bin/mailq"
+)
+
+// Messages lists the queue, filtered by the patterns, postqueue -j is used and mailq when postfix is too old for it
+func (q *MailQueue) Messages() ([]mail.Message, error) {
+	sender, recipient, err := q.patterns()
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := queueMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := []mail.Message{}
+	for _, message := range messages {
+		if message.Match(sender, recipient) {
+			matched = append(matched, message)
+		}
+	}
+
+	return matched, nil
+}
+
+func (q *MailQueue) Headers() ([]mail.Header, error) {
+	if !mail.QueueId.MatchString(q.Id) {
+		return nil, errors.New("Invalid queue id: " + q.Id)
+	}
+
+	output, err := runner.Run(postcat, "-h", "-q", q.Id)
+	if err != nil {
+		return nil, errors.New(err.Error() + ": " + string(output) + " - Queue id: " + q.Id)
+	}
+
+	return mail.ParseHeaders(string(output)), nil
+}
+
+// Flush retries the delivery of the selected messages, the whole queue when nothing is selected
+func (q *MailQueue) Flush() ([]string, error) {
+	if q.isEmpty() {
+		output, err := runner.Run(postqueue, "-f")
+		if err != nil {
+			return nil, errors.New(err.Error() + ": " + string(output))
+		}
+		return []string{}, nil
+	}
+
+	ids, err := q.selected()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		output, err := runner.Run(postqueue, "-i", id)
+		if err != nil {
+			return nil, errors.New(err.Error() + ": " + string(output) + " - Queue id: " + id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (q *MailQueue) Hold() ([]string, error) {
+	return q.super("-h")
+}
+
+func (q *MailQueue) Release() ([]string, error) {
+	return q.super("-H")
+}
+
+func (q *MailQueue) Delete() ([]string, error) {
+	return q.super("-d")
+}
+
+// super runs postsuper with the selected ids on standard input, nothing is selected is an error so that
+// an empty request never touches the whole queue
+func (q *MailQueue) super(flag string) ([]string, error) {
+	if q.isEmpty() {
+		return nil, errors.New("No queued messages selected")
+	}
+
+	ids, err := q.selected()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	output, err := runner.Stream(strings.NewReader(strings.Join(ids, "\n")+"\n"), postsuper, flag, "-")
+	if err != nil {
+		return nil, errors.New(err.Error() + ": " + string(output))
+	}
+
+	return ids, nil
+}
+
+// selected returns the ids of the request and of the queued messages matching its patterns
+func (q *MailQueue) selected() ([]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, id := range append([]string{q.Id}, q.Ids...) {
+		if id == "" || seen[id] {
+			continue
+		}
+		if !mail.QueueId.MatchString(id) {
+			return nil, errors.New("Invalid queue id: " + id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	if q.Sender == "" && q.Recipient == "" {
+		return ids, nil
+	}
+	messages, err := q.Messages()
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range messages {
+		if !seen[message.Id] {
+			seen[message.Id] = true
+			ids = append(ids, message.Id)
+		}
+	}
+
+	return ids, nil
+}
+
+func (q *MailQueue) isEmpty() bool {
+	return q.Id == "" && len(q.Ids) == 0 && q.Sender == "" && q.Recipient == ""
+}
+
+func (q *MailQueue) patterns() (*regexp.Regexp, *regexp.Regexp, error) {
+	var sender, recipient *regexp.Regexp
+	var err error
+	if q.Sender != "" {
+		if sender, err = regexp.Compile("(?i)" + q.Sender); err != nil {
+			return nil, nil, err
+		}
+	}
+	if q.Recipient != "" {
+		if recipient, err = regexp.Compile("(?i)" + q.Recipient); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return sender, recipient, nil
+}
+
+func queueMessages() ([]mail.Message, error) {
+	output, err := runner.Run(postqueue, "-j")
+	if err == nil {
+		return mail.ParseQueueJson(string(output))
+	}
+
+	output, err = runner.Run(mailq)
+	if err != nil {
+		return nil, errors.New(err.Error() + ": " + string(output))
+	}
+
+	return mail.ParseMailq(string(output), time.Now()), nil
+}
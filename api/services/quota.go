@@ -0,0 +1,149 @@
+package services
+
+import (
+	"agent/api/notify"
+	"fmt"
+	"github.com/getsentry/sentry-go"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	QuotaLevelOk       = "ok"
+	QuotaLevelWarning  = "warning"
+	QuotaLevelCritical = "critical"
+)
+
+type QuotaUsage struct {
+	Share      string    `json:"share"`
+	Path       string    `json:"path"`
+	ZfsPath    string    `json:"zfs_path"`
+	Used       int64     `json:"used"`
+	Referenced int64     `json:"referenced"`
+	RefQuota   int64     `json:"refquota"`
+	Percent    float64   `json:"percent"`
+	Level      string    `json:"level"`
+	Collected  time.Time `json:"collected"`
+}
+
+var (
+	quotaMutex  sync.Mutex
+	quotaUsages []QuotaUsage
+	quotaLevels = make(map[string]string)
+)
+
+// StartQuotaMonitor collects the share usage every interval and alerts when a share reaches a threshold
+func StartQuotaMonitor() {
+	interval := time.Duration(ShareSettings.Quota.Interval) * time.Second
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			if _, err := CollectQuota(); err != nil {
+				sentry.CaptureException(err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func QuotaUsages() ([]QuotaUsage, error) {
+	quotaMutex.Lock()
+	usages := quotaUsages
+	quotaMutex.Unlock()
+
+	if usages == nil {
+		return CollectQuota()
+	}
+
+	return usages, nil
+}
+
+// CollectQuota reads used and refquota of the datasets mounted at share paths, the percent is referenced/refquota
+func CollectQuota() ([]QuotaUsage, error) {
+	shares, err := readShares()
+	if err != nil {
+		return nil, err
+	}
+	datasets, err := zfsClient().Filesystems()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	usages := []QuotaUsage{}
+	for _, share := range shares.Shares {
+		if share.Path == "" {
+			continue
+		}
+		for _, dataset := range datasets {
+			if filepath.Clean(dataset.Mountpoint) != filepath.Clean(share.Path) {
+				continue
+			}
+
+			usage := QuotaUsage{
+				Share:      share.Name,
+				Path:       share.Path,
+				ZfsPath:    dataset.Name,
+				Used:       dataset.Used,
+				Referenced: dataset.Referenced,
+				RefQuota:   dataset.RefQuota,
+				Level:      QuotaLevelOk,
+				Collected:  now,
+			}
+			if dataset.RefQuota > 0 {
+				usage.Percent = float64(dataset.Referenced) * 100 / float64(dataset.RefQuota)
+				usage.Level = quotaLevel(usage.Percent)
+			}
+			usages = append(usages, usage)
+			break
+		}
+	}
+	sort.Slice(usages, func(i, j int) bool { return usages[i].Share < usages[j].Share })
+
+	quotaMutex.Lock()
+	quotaUsages = usages
+	var alerts []QuotaUsage
+	for _, usage := range usages {
+		previous := quotaLevels[usage.Share]
+		quotaLevels[usage.Share] = usage.Level
+		if usage.Level != QuotaLevelOk && usage.Level != previous && !(previous == QuotaLevelCritical && usage.Level == QuotaLevelWarning) {
+			alerts = append(alerts, usage)
+		}
+	}
+	quotaMutex.Unlock()
+
+	for _, usage := range alerts {
+		subject := fmt.Sprintf("Share %s quota %s", usage.Share, usage.Level)
+		text := fmt.Sprintf("Share %s (%s) uses %.1f%% of its refquota: %d of %d bytes", usage.Share, usage.ZfsPath, usage.Percent, usage.Referenced, usage.RefQuota)
+		if err = notify.Send(subject, text); err != nil {
+			sentry.CaptureException(err)
+		}
+	}
+
+	return usages, nil
+}
+
+func quotaLevel(percent float64) string {
+	critical := ShareSettings.Quota.Critical
+	if critical <= 0 {
+		critical = 95
+	}
+	warning := ShareSettings.Quota.Warning
+	if warning <= 0 {
+		warning = 80
+	}
+
+	switch {
+	case percent >= critical:
+		return QuotaLevelCritical
+	case percent >= warning:
+		return QuotaLevelWarning
+	}
+
+	return QuotaLevelOk
+}
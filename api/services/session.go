@@ -0,0 +1,160 @@
+package services
+
+import (
+	"agent/api/samba"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type SessionFilter struct {
+	Share string `json:"share" form:"share"`
+	User  string `json:"user" form:"user"`
+}
+
+type SessionClose struct {
+	Pid   string `json:"pid"`
+	Share string `json:"share"`
+}
+
+var pidPattern = regexp.MustCompile(`^[0-9]+$`)
+
+func (f *SessionFilter) Sessions() ([]samba.Session, error) {
+	status, err := samba.ReadStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	pids := f.pids(status)
+
+	sessions := []samba.Session{}
+	for _, session := range status.Sessions {
+		if pids == nil || pids[session.Pid] {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (f *SessionFilter) Connections() ([]samba.Connection, error) {
+	status, err := samba.ReadStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	pids := f.pids(status)
+
+	connections := []samba.Connection{}
+	for _, connection := range status.Connections {
+		if pids == nil || pids[connection.Pid] {
+			connections = append(connections, connection)
+		}
+	}
+
+	return connections, nil
+}
+
+func (f *SessionFilter) Locks() ([]samba.Lock, error) {
+	status, err := samba.ReadStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	pids := f.pids(status)
+
+	sharePath := ""
+	if f.Share != "" {
+		shares, err := readShares()
+		if err != nil {
+			return nil, err
+		}
+		if share, err := shares.Share(f.Share); err == nil {
+			sharePath = filepath.Clean(share.Path)
+		}
+	}
+
+	locks := []samba.Lock{}
+	for _, lock := range status.Locks {
+		if pids != nil && !pids[lock.Pid] {
+			continue
+		}
+		if sharePath != "" && filepath.Clean(lock.SharePath) != sharePath {
+			continue
+		}
+		locks = append(locks, lock)
+	}
+
+	return locks, nil
+}
+
+// pids returns the smbd processes matching the filter, nil means no filter
+func (f *SessionFilter) pids(status samba.Status) map[string]bool {
+	if f.Share == "" && f.User == "" {
+		return nil
+	}
+
+	pids := make(map[string]bool)
+	for _, session := range status.Sessions {
+		if f.User == "" || strings.EqualFold(session.Username, f.User) || strings.HasSuffix(strings.ToLower(session.Username), `\`+strings.ToLower(f.User)) {
+			pids[session.Pid] = true
+		}
+	}
+
+	if f.Share != "" {
+		sharePids := make(map[string]bool)
+		for _, connection := range status.Connections {
+			if strings.EqualFold(connection.Service, f.Share) && (f.User == "" || pids[connection.Pid]) {
+				sharePids[connection.Pid] = true
+			}
+		}
+		pids = sharePids
+	}
+
+	return pids
+}
+
+func (s *SessionClose) Disconnect() error {
+	if err := checkSessionPid(s.Pid); err != nil {
+		return err
+	}
+
+	return samba.Disconnect(s.Pid)
+}
+
+// CloseShare closes the share for one smbd process or, without pid, for every client
+func (s *SessionClose) CloseShare() error {
+	if s.Share == "" || strings.ContainsAny(s.Share, "\n") {
+		return errors.New("Invalid share name: " + s.Share)
+	}
+
+	pid := "smbd"
+	if s.Pid != "" {
+		if err := checkSessionPid(s.Pid); err != nil {
+			return err
+		}
+		pid = s.Pid
+	}
+
+	return samba.CloseShare(pid, s.Share)
+}
+
+// checkSessionPid accepts only the pid of an smbd process serving a client session, smbcontrol would signal any process
+func checkSessionPid(pid string) error {
+	if !pidPattern.MatchString(pid) {
+		return errors.New("Invalid pid: " + pid)
+	}
+
+	status, err := samba.ReadStatus()
+	if err != nil {
+		return err
+	}
+	for _, session := range status.Sessions {
+		if session.Pid == pid {
+			return nil
+		}
+	}
+
+	return errors.New("Pid is not a samba session: " + pid)
+}
@@ -1,18 +1,30 @@
 package services
 
 import (
+	"agent/api/samba"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
+	"os"
 	"os/exec"
 )
 
 type Samba struct {
 	Enabled bool
+	Mode    string
 	Path    struct {
 		Prod string
 		Temp string
 	}
+	Quota struct {
+		Interval int
+		Warning  float64
+		Critical float64
+	}
+	Trash struct {
+		Dataset   string
+		GraceDays int `yaml:"grace_days"`
+	}
 }
 
 type ShareString struct {
@@ -21,46 +33,66 @@ type ShareString struct {
 
 var ShareSettings Samba
 
-func (s *ShareString) Download() error {
+// Download replaces smb.conf, false is returned when the assembled config is the same as prod
+func (s *ShareString) Download() (bool, error) {
 	line := s.Data["samba"]
 
 	return download(line)
 }
 
-func download(line interface{}) error {
+// download installs the config, samba is not restarted and its sessions are kept when nothing changed
+func download(line interface{}) (bool, error) {
 	temp := ShareSettings.Path.Temp
 	recv := temp + "/smb.conf.recv"
 	if err := ioutil.WriteFile(recv, []byte(line.(string)), 0644); err != nil {
-		return err
+		return false, err
 	}
 
 	head := temp + "/smb.conf.head"
 	conf := temp + "/smb.conf"
 	if err := commit(head, recv, conf); err != nil {
-		return err
+		return false, err
 	}
 
 	//if err := sambaTestConfig(conf); err != nil {
 	//	return err
 	//}
 
-	confData, err := ioutil.ReadFile(conf)
+	prod := ShareSettings.Path.Prod + "/smb.conf"
+	same, err := sameChecksum(conf, prod)
+	if err != nil || same {
+		return false, err
+	}
+
+	if err = install(map[string]string{prod: conf}, restartSamba); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *ShareString) Create() error {
+	shares, err := s.shares()
 	if err != nil {
 		return err
 	}
-
-	if err := ioutil.WriteFile(ShareSettings.Path.Prod+"/smb.conf", confData, 0644); err != nil {
+	existing, err := readShares()
+	if err != nil {
 		return err
 	}
-
-	if err = restartSamba(); err != nil {
+	for _, share := range shares {
+		if err = existing.Add(share); err != nil {
+			return err
+		}
+	}
+	permissions, err := sharePermissions(s.Data)
+	if err != nil {
+		return err
+	}
+	if err = permissions.validate(); err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (s *ShareString) Create() error {
 	zfs := int(s.Data["is_zfs"].(float64))
 	quota := s.Data["quota"]
 	path := s.Data["path"]
@@ -79,54 +111,131 @@ func (s *ShareString) Create() error {
 		}
 	}
 
-	cli := exec.Command("/usr/bin/chmod", "777", path.(string))
-	output, err := cli.CombinedOutput()
+	if err = permissions.Apply(); err != nil {
+		return err
+	}
+
+	return changeShares(func(conf *samba.Conf) error {
+		for _, share := range shares {
+			if err := conf.Add(share); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ShareString) Update() error {
+	shares, err := s.shares()
 	if err != nil {
-		return errors.New(err.Error() + ": " + string(output))
+		return err
 	}
 
-	line := s.Data["samba"]
+	return changeShares(func(conf *samba.Conf) error {
+		for _, share := range shares {
+			if err := conf.Update(share); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *ShareString) Rename() error {
+	name, _ := s.Data["name"].(string)
+	newName, _ := s.Data["new_name"].(string)
+
+	return changeShares(func(conf *samba.Conf) error {
+		return conf.Rename(name, newName)
+	})
+}
+
+// shares returns the shares of the request, either the structured "share" or sections of the raw "samba" text
+func (s *ShareString) shares() ([]*samba.Section, error) {
+	if data, ok := s.Data["share"]; ok {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		var share samba.Section
+		if err = json.Unmarshal(encoded, &share); err != nil {
+			return nil, err
+		}
+		return []*samba.Section{&share}, nil
+	}
+
+	line, ok := s.Data["samba"].(string)
+	if !ok {
+		return nil, errors.New("Share is empty")
+	}
+
+	return samba.ParseSections(line)
+}
+
+func readShares() (*samba.Conf, error) {
+	temp := ShareSettings.Path.Temp
+	headData, err := ioutil.ReadFile(temp + "/smb.conf.head")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	recvData, err := ioutil.ReadFile(temp + "/smb.conf.recv")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return samba.ParseConf(string(headData), string(recvData))
+}
 
+// changeShares applies the change to the parsed smb.conf.recv and installs the rendered config,
+// samba is restarted only when the config changed
+func changeShares(change func(conf *samba.Conf) error) error {
 	temp := ShareSettings.Path.Temp
+	head := temp + "/smb.conf.head"
 	recv := temp + "/smb.conf.recv"
 	backup := temp + "/smb.conf.recv.backup"
+	conf := temp + "/smb.conf"
+
 	if err := beginTransaction(recv, backup); err != nil {
-		if err := rollback(recv, backup); err != nil {
-			return err
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
 		}
 		return err
 	}
 
-	if err := add(recv, line.(string)); err != nil {
-		if err := rollback(recv, backup); err != nil {
-			return err
-		}
+	shares, err := readShares()
+	if err != nil {
 		return err
 	}
-
-	head := temp + "/smb.conf.head"
-	conf := temp + "/smb.conf"
-	if err := commit(head, recv, conf); err != nil {
+	if err = change(shares); err != nil {
 		return err
 	}
 
-	//if err := sambaTestConfig(conf); err != nil {
-	//	return err
-	//}
-
-	confData, err := ioutil.ReadFile(conf)
-	if err != nil {
-		if err := rollback(recv, backup); err != nil {
-			return err
+	if err = ioutil.WriteFile(recv, []byte(shares.Render()), 0644); err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
 		}
 		return err
 	}
 
-	if err := ioutil.WriteFile(ShareSettings.Path.Prod+"/smb.conf", confData, 0644); err != nil {
+	if err = commit(head, recv, conf); err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
+		}
 		return err
 	}
 
-	if err = restartSamba(); err != nil {
+	prod := ShareSettings.Path.Prod + "/smb.conf"
+	same, err := sameChecksum(conf, prod)
+	if err == nil && same {
+		return nil
+	}
+	if err == nil {
+		err = install(map[string]string{prod: conf}, restartSamba)
+	}
+	if err != nil {
+		if rollbackErr := rollback(recv, backup); rollbackErr != nil {
+			return rollbackErr
+		}
 		return err
 	}
 
@@ -146,43 +255,46 @@ func (s *ShareString) Quota() error {
 	return nil
 }
 
+// Delete removes the share from smb.conf and moves its dataset to the trash, it is destroyed with the remote copy by PurgeTrash
 func (s *ShareString) Delete() error {
-	line := s.Data["samba"]
-
-	err := download(line)
-
-	zfsPath := s.Data["zfs_path"]
-	backupServer := s.Data["backup_server"]
-	backupServerPool := s.Data["backup_server_pool"]
-	name := s.Data["name"]
-
-	cli := exec.Command("/sbin/zfs", "list", zfsPath.(string))
-	_, err = cli.CombinedOutput()
+	name, _ := s.Data["name"].(string)
+	existing, err := readShares()
 	if err != nil {
-		return nil
+		return err
 	}
+	section, _ := existing.Share(name)
 
-	command := fmt.Sprintf("/sbin/zfs destroy -fr %s", zfsPath.(string))
-	cli = exec.Command("/usr/bin/bash", "-c", command)
-	output, err := cli.CombinedOutput()
+	if line, ok := s.Data["samba"]; ok {
+		_, err = download(line)
+	} else {
+		err = changeShares(func(conf *samba.Conf) error {
+			return conf.Delete(name)
+		})
+	}
 	if err != nil {
-		return errors.New(err.Error() + ": " + string(output))
+		return err
 	}
 
-	cli = exec.Command("ssh", backupServer.(string), "zfs", "list", backupServerPool.(string)+"/"+name.(string))
-	_, err = cli.CombinedOutput()
+	zfsPath, _ := s.Data["zfs_path"].(string)
+	if zfsPath == "" {
+		return nil
+	}
+	exists, err := zfsClient().Exists(zfsPath)
 	if err != nil {
+		return err
+	}
+	if !exists {
 		return nil
 	}
 
-	command = fmt.Sprintf("ssh %s zfs destroy -fr %s/%s", backupServer.(string), backupServerPool.(string), name.(string))
-	cli = exec.Command("/usr/bin/bash", "-c", command)
-	output, err = cli.CombinedOutput()
-	if err != nil {
-		return errors.New(err.Error() + ": " + string(output))
+	backupServer, _ := s.Data["backup_server"].(string)
+	backupServerPool, _ := s.Data["backup_server_pool"].(string)
+	remoteFs := ""
+	if backupServerPool != "" && name != "" {
+		remoteFs = backupServerPool + "/" + name
 	}
 
-	return nil
+	return moveToTrash(zfsPath, name, section, backupServer, remoteFs)
 }
 
 //func sambaTestConfig(conf string) error {
@@ -1,11 +1,13 @@
 package services
 
 import (
+	"agent/api/mail"
 	"errors"
 	"io/ioutil"
 	"os"
 	"os/exec"
-	"regexp"
+	"sort"
+	"strings"
 )
 
 type Smtp struct {
@@ -15,13 +17,14 @@ type Smtp struct {
 		Forward string
 		Aliases string
 	}
-}
-
-type Techmail struct {
-	Enabled bool
-	Path    struct {
-		Prod string
-		Temp string
+	Maps struct {
+		Path  string
+		Type  string
+		Names []string
+	}
+	Log struct {
+		Path      string
+		TailBytes int64 `yaml:"tail_bytes"`
 	}
 }
 
@@ -37,233 +40,638 @@ type SmtpMap struct {
 	Data map[string]map[string]string
 }
 
-var (
-	SmtpSettings     Smtp
-	TechmailSettings Techmail
-)
+type SmtpAlias struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+}
 
-func smtpCommit(name string, recv string, temp string, forward string, aliases string) error {
-	head := temp + "/" + name + ".head"
-	conf := forward + "/" + name
-	if name == "aliases" {
-		conf = aliases + "/" + name
-	}
-	if err := commit(head, recv, conf); err != nil {
-		return err
-	}
+type SmtpForward struct {
+	ForwardName string `json:"forward_name" form:"forward_name"`
+	Target      string `json:"target"`
+}
 
-	return nil
+var SmtpSettings Smtp
+
+// smtpTransaction collects the changes of several smtp files, nothing is promoted before all of them are assembled and validated
+type smtpTransaction struct {
+	aliases  *mail.Aliases
+	forwards map[string][]mail.Target
+	renames  map[string]string
+	removes  map[string]bool
 }
 
-func (s *SmtpString) SmtpDownload() error {
-	lines := s.Data
+// aliasesDatabases are the suffixes of the database newaliases builds next to the aliases file
+var aliasesDatabases = []string{".db", ".lmdb", ".cdb", ".dir", ".pag"}
 
-	temp := SmtpSettings.Path.Temp
-	forward := SmtpSettings.Path.Forward
-	aliases := SmtpSettings.Path.Aliases
-	for name, line := range lines {
-		recv := temp + "/" + name + ".recv"
-		if err := ioutil.WriteFile(recv, []byte(line.(string)), 0644); err != nil {
+// SmtpDownload replaces the files, the aliases are checked for duplicates and loops before anything is written
+func (s *SmtpString) SmtpDownload() (bool, error) {
+	transaction := newSmtpTransaction()
+	for name, line := range s.Data {
+		text, ok := line.(string)
+		if !ok {
+			return false, errors.New("Invalid smtp file: " + name)
+		}
+		if name == "aliases" {
+			aliases, err := parseAliases(text)
+			if err != nil {
+				return false, err
+			}
+			transaction.aliases = aliases
+			continue
+		}
+		if err := validForwardName(name); err != nil {
+			return false, err
+		}
+		targets, err := mail.ParseForward(text)
+		if err != nil {
+			return false, err
+		}
+		transaction.forwards[name] = targets
+	}
+
+	return transaction.Commit()
+}
+
+// Create adds the alias entries or forward targets of each file
+func (s *SmtpString) Create() error {
+	transaction := newSmtpTransaction()
+	for name, line := range s.Data {
+		text, ok := line.(string)
+		if !ok {
+			return errors.New("Invalid smtp file: " + name)
+		}
+		if err := transaction.Append(name, text); err != nil {
 			return err
 		}
+	}
+
+	_, err := transaction.Commit()
+
+	return err
+}
 
-		if err := smtpCommit(name, recv, temp, forward, aliases); err != nil {
+// ForwardRename renames the forward files of "file_name" and changes the other files in one transaction
+func (s *SmtpMap) ForwardRename() error {
+	transaction := newSmtpTransaction()
+	for find, replace := range s.Data["file_name"] {
+		if err := transaction.Rename(find, replace); err != nil {
+			return err
+		}
+	}
+	for name, line := range s.Data {
+		if name == "file_name" {
+			continue
+		}
+		if err := transaction.Replace(name, line); err != nil {
 			return err
 		}
 	}
 
-	if err := newaliases(); err != nil {
-		return err
+	_, err := transaction.Commit()
+
+	return err
+}
+
+// ForwardDelete changes the files and removes the forward file of "forward_name" in one transaction
+func (s *SmtpString) ForwardDelete() error {
+	transaction := newSmtpTransaction()
+	for name, line := range s.Data {
+		if name == "forward_name" {
+			continue
+		}
+		if err := transaction.Delete(name, []string{line.(string)}); err != nil {
+			return err
+		}
+	}
+	if line, ok := s.Data["forward_name"]; ok {
+		if err := transaction.Remove(line.(string)); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	_, err := transaction.Commit()
+
+	return err
 }
 
-func (s *SmtpString) TechMailDownload() error {
-	lines := s.Data
-	for name, line := range lines {
-		recv := TechmailSettings.Path.Temp + "/" + name + ".recv"
-		if err := ioutil.WriteFile(recv, []byte(line.(string)), 0644); err != nil {
+func (s *SmtpMap) UserUpdate() error {
+	transaction := newSmtpTransaction()
+	for name, line := range s.Data {
+		if err := transaction.Replace(name, line); err != nil {
 			return err
 		}
+	}
+
+	_, err := transaction.Commit()
+
+	return err
+}
 
-		head := TechmailSettings.Path.Temp + "/" + name + ".head"
-		conf := TechmailSettings.Path.Prod + "/" + name
-		if err := commit(head, recv, conf); err != nil {
+func (s *SmtpSlice) UserDelete() error {
+	transaction := newSmtpTransaction()
+	for name, line := range s.Data {
+		if err := transaction.Delete(name, line); err != nil {
 			return err
 		}
 	}
 
-	if err := newaliases(); err != nil {
+	_, err := transaction.Commit()
+
+	return err
+}
+
+func SmtpAliases() ([]*mail.Alias, error) {
+	aliases, err := readAliases()
+	if err != nil {
+		return nil, err
+	}
+
+	return aliases.Entries, nil
+}
+
+func (s *SmtpAlias) AddTarget() error {
+	target, err := mail.ParseTarget(s.Target)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	transaction := newSmtpTransaction()
+	aliases, err := transaction.Aliases()
+	if err != nil {
+		return err
+	}
+	if err = aliases.AddTarget(s.Name, target); err != nil {
+		return err
+	}
+
+	_, err = transaction.Commit()
+
+	return err
 }
 
-func (s *SmtpString) Create() error {
-	lines := s.Data
+func (s *SmtpAlias) RemoveTarget() error {
+	target, err := mail.ParseTarget(s.Target)
+	if err != nil {
+		return err
+	}
 
-	temp := SmtpSettings.Path.Temp
-	forward := SmtpSettings.Path.Forward
-	aliases := SmtpSettings.Path.Aliases
-	for name, line := range lines {
-		recv := temp + "/" + name + ".recv"
-		if err := add(recv, line.(string)); err != nil {
-			return err
-		}
+	transaction := newSmtpTransaction()
+	aliases, err := transaction.Aliases()
+	if err != nil {
+		return err
+	}
+	if err = aliases.RemoveTarget(s.Name, target); err != nil {
+		return err
+	}
 
-		if err := smtpCommit(name, recv, temp, forward, aliases); err != nil {
-			return err
+	_, err = transaction.Commit()
+
+	return err
+}
+
+func (s *SmtpForward) Targets() ([]mail.Target, error) {
+	return newSmtpTransaction().Targets(s.ForwardName)
+}
+
+func (s *SmtpForward) AddTarget() error {
+	target, err := mail.ParseTarget(s.Target)
+	if err != nil {
+		return err
+	}
+
+	transaction := newSmtpTransaction()
+	targets, err := transaction.Targets(s.ForwardName)
+	if err != nil {
+		return err
+	}
+	for _, existing := range targets {
+		if existing.Equal(target) {
+			return errors.New("Duplicate target of forward " + s.ForwardName + ": " + target.Render())
 		}
 	}
+	transaction.forwards[s.ForwardName] = append(targets, target)
 
-	if err := newaliases(); err != nil {
+	_, err = transaction.Commit()
+
+	return err
+}
+
+func (s *SmtpForward) RemoveTarget() error {
+	target, err := mail.ParseTarget(s.Target)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	transaction := newSmtpTransaction()
+	targets, err := transaction.Targets(s.ForwardName)
+	if err != nil {
+		return err
+	}
+	kept := withoutTarget(targets, target)
+	if len(kept) == len(targets) {
+		return errors.New("Forward " + s.ForwardName + " has no target: " + target.Render())
+	}
+	transaction.forwards[s.ForwardName] = kept
+
+	_, err = transaction.Commit()
+
+	return err
 }
 
-func (s *SmtpMap) ForwardRename() error {
-	lines := s.Data
+func newSmtpTransaction() *smtpTransaction {
+	return &smtpTransaction{
+		forwards: make(map[string][]mail.Target),
+		renames:  make(map[string]string),
+		removes:  make(map[string]bool),
+	}
+}
 
-	temp := SmtpSettings.Path.Temp
-	forward := SmtpSettings.Path.Forward
-	aliases := SmtpSettings.Path.Aliases
-	for name, line := range lines {
-		if name == "file_name" {
-			for find, replace := range line {
-				headOld := temp + "/" + find + ".head"
-				headNew := temp + "/" + replace + ".head"
-				if err := os.Rename(headOld, headNew); err != nil {
-					return err
-				}
+// Aliases returns the aliases of the transaction, read from aliases.recv on first use
+func (t *smtpTransaction) Aliases() (*mail.Aliases, error) {
+	if t.aliases == nil {
+		aliases, err := readAliases()
+		if err != nil {
+			return nil, err
+		}
+		t.aliases = aliases
+	}
 
-				recvOld := temp + "/" + find + ".recv"
-				recvNew := temp + "/" + replace + ".recv"
-				if err := os.Rename(recvOld, recvNew); err != nil {
-					return err
-				}
+	return t.aliases, nil
+}
 
-				prodFind := forward + "/" + find
-				prodReplace := forward + "/" + replace
-				if err := os.Rename(prodFind, prodReplace); err != nil {
-					return err
-				}
-			}
-		} else {
-			recv := temp + "/" + name + ".recv"
-			if err := update(recv, line); err != nil {
-				return err
-			}
+// Targets returns the targets of the forward file in the transaction, read from its recv on first use,
+// a renamed file is read from the recv of its old name
+func (t *smtpTransaction) Targets(name string) ([]mail.Target, error) {
+	if err := validForwardName(name); err != nil {
+		return nil, err
+	}
+	if targets, ok := t.forwards[name]; ok {
+		return targets, nil
+	}
+	if _, ok := t.renames[name]; ok || t.removes[name] {
+		return nil, errors.New("Forward is renamed or removed: " + name)
+	}
 
-			if err := smtpCommit(name, recv, temp, forward, aliases); err != nil {
-				return err
-			}
+	source := name
+	for find, replace := range t.renames {
+		if replace == name {
+			source = find
 		}
 	}
 
-	if err := newaliases(); err != nil {
+	return readTargets(SmtpSettings.Path.Temp + "/" + source + ".recv")
+}
+
+// Rename renames the head, recv and promoted file of the forward when the transaction is committed
+func (t *smtpTransaction) Rename(find string, replace string) error {
+	if err := validForwardName(find); err != nil {
+		return err
+	}
+	if err := validForwardName(replace); err != nil {
 		return err
 	}
+	if _, ok := t.forwards[find]; ok {
+		return errors.New("Forward is changed before its rename: " + find)
+	}
+	t.renames[find] = replace
 
 	return nil
 }
 
-func (s *SmtpString) ForwardDelete() error {
-	lines := s.Data
+// Remove removes the head, recv and promoted file of the forward when the transaction is committed
+func (t *smtpTransaction) Remove(name string) error {
+	if err := validForwardName(name); err != nil {
+		return err
+	}
+	delete(t.forwards, name)
+	t.removes[name] = true
+
+	return nil
+}
 
+// move renames and removes the forward files of the transaction
+func (t *smtpTransaction) move() error {
 	temp := SmtpSettings.Path.Temp
-	forward := SmtpSettings.Path.Forward
-	aliases := SmtpSettings.Path.Aliases
-	for name, line := range lines {
-		if name == "forward_name" {
-			head := temp + "/" + line.(string) + ".head"
-			if err := os.Remove(head); err != nil {
+	for find, replace := range t.renames {
+		for _, suffix := range []string{".head", ".recv"} {
+			if err := os.Rename(temp+"/"+find+suffix, temp+"/"+replace+suffix); err != nil {
 				return err
 			}
-
-			recv := temp + "/" + line.(string) + ".recv"
-			if err := os.Remove(recv); err != nil {
+		}
+		if err := os.Rename(smtpConf(find), smtpConf(replace)); err != nil {
+			return err
+		}
+	}
+	for name := range t.removes {
+		for _, path := range []string{temp + "/" + name + ".head", temp + "/" + name + ".recv", smtpConf(name)} {
+			if err := os.Remove(path); err != nil {
 				return err
 			}
+		}
+	}
 
-			prodDelete := forward + "/" + line.(string)
-			if err := os.Remove(prodDelete); err != nil {
-				return err
-			}
-		} else {
-			recv := temp + "/" + name + ".recv"
+	return nil
+}
 
-			recvData, err := ioutil.ReadFile(recv)
+// Append adds the alias entries of the text to the aliases file or its targets to the forward file
+func (t *smtpTransaction) Append(name string, text string) error {
+	if name == "aliases" {
+		entries, err := mail.ParseEntries(text)
+		if err != nil {
+			return err
+		}
+		aliases, err := t.Aliases()
+		if err != nil {
+			return err
+		}
+		aliases.Entries = append(aliases.Entries, entries...)
+		return nil
+	}
+
+	added, err := mail.ParseForward(text)
+	if err != nil {
+		return err
+	}
+	targets, err := t.Targets(name)
+	if err != nil {
+		return err
+	}
+	t.forwards[name] = append(targets, added...)
+
+	return nil
+}
+
+// Replace replaces the alias or target named by each key with the entries or targets of its value
+func (t *smtpTransaction) Replace(name string, lines map[string]string) error {
+	if name == "aliases" {
+		aliases, err := t.Aliases()
+		if err != nil {
+			return err
+		}
+		for find, replace := range lines {
+			if _, err = aliases.Alias(aliasName(find)); err == nil {
+				if err = aliases.Delete(aliasName(find)); err != nil {
+					return err
+				}
+			}
+			entries, err := mail.ParseEntries(replace)
 			if err != nil {
 				return err
 			}
-			recvString := string(recvData)
-			re := regexp.MustCompile("(?m)^" + line.(string) + `.*$[\r\n]*|[\r\n]+\s+\z`)
-			recvString = re.ReplaceAllString(recvString, "")
+			aliases.Entries = append(aliases.Entries, entries...)
+		}
+		return nil
+	}
 
-			if err := ioutil.WriteFile(recv, []byte(recvString), 0644); err != nil {
-				return err
-			}
+	targets, err := t.Targets(name)
+	if err != nil {
+		return err
+	}
+	for find, replace := range lines {
+		target, err := mail.ParseTarget(find)
+		if err != nil {
+			return err
+		}
+		targets = withoutTarget(targets, target)
+		added, err := mail.ParseTargets(replace)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, added...)
+	}
+	t.forwards[name] = targets
 
-			if err := smtpCommit(name, recv, temp, forward, aliases); err != nil {
+	return nil
+}
+
+// Delete deletes the named aliases from the aliases file or the targets from the forward file,
+// a missing alias is skipped
+func (t *smtpTransaction) Delete(name string, lines []string) error {
+	if name == "aliases" {
+		aliases, err := t.Aliases()
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if _, err = aliases.Alias(aliasName(line)); err != nil {
+				continue
+			}
+			if err = aliases.Delete(aliasName(line)); err != nil {
 				return err
 			}
 		}
+		return nil
 	}
 
-	if err := newaliases(); err != nil {
+	targets, err := t.Targets(name)
+	if err != nil {
 		return err
 	}
+	for _, line := range lines {
+		target, err := mail.ParseTarget(line)
+		if err != nil {
+			return err
+		}
+		targets = withoutTarget(targets, target)
+	}
+	t.forwards[name] = targets
 
 	return nil
 }
 
-func (s *SmtpMap) UserUpdate() error {
-	lines := s.Data
-
+// Commit renames and removes the forward files, validates the files, writes their recv and assembles them next
+// to it, then promotes them together and runs newaliases; every head, recv, promoted file and the aliases
+// database is restored on failure, files identical to the promoted ones are skipped and false is returned
+// when nothing changed
+func (t *smtpTransaction) Commit() (bool, error) {
 	temp := SmtpSettings.Path.Temp
-	forward := SmtpSettings.Path.Forward
-	aliases := SmtpSettings.Path.Aliases
-	for name, line := range lines {
+	for name, targets := range t.forwards {
+		for i, target := range targets {
+			for _, other := range targets[:i] {
+				if target.Equal(other) {
+					return false, errors.New("Duplicate target of forward " + name + ": " + target.Render())
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(t.forwards)+1)
+	for name := range t.forwards {
+		names = append(names, name)
+	}
+	if t.aliases != nil {
+		names = append(names, "aliases")
+	}
+	sort.Strings(names)
+
+	files := make(map[string]string)
+	for _, name := range names {
+		files[temp+"/"+name+".recv"] = temp + "/" + name + ".recv.backup"
+		files[smtpConf(name)] = temp + "/" + name + ".prod.backup"
+	}
+	moved := make([]string, 0, 2*len(t.renames)+len(t.removes))
+	for find, replace := range t.renames {
+		moved = append(moved, find, replace)
+	}
+	for name := range t.removes {
+		moved = append(moved, name)
+	}
+	for _, name := range moved {
+		files[temp+"/"+name+".head"] = temp + "/" + name + ".head.backup"
+		files[temp+"/"+name+".recv"] = temp + "/" + name + ".recv.backup"
+		files[smtpConf(name)] = temp + "/" + name + ".prod.backup"
+	}
+	if t.aliases != nil || len(moved) > 0 {
+		for _, suffix := range aliasesDatabases {
+			files[smtpConf("aliases")+suffix] = temp + "/aliases" + suffix + ".backup"
+		}
+	}
+	backups, err := backupFiles(files)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		for _, name := range names {
+			_ = os.Remove(temp + "/" + name + ".staged")
+		}
+	}()
+
+	if err = t.move(); err != nil {
+		return false, backups.restore(err)
+	}
+
+	rendered := make(map[string]string)
+	included := make(map[string][]mail.Target)
+	for name, targets := range t.forwards {
+		headTargets, err := readTargets(temp + "/" + name + ".head")
+		if err != nil {
+			return false, backups.restore(err)
+		}
+		included[SmtpSettings.Path.Forward+"/"+name] = append(headTargets, targets...)
+		rendered[name] = mail.RenderTargets(targets)
+	}
+
+	aliases := t.aliases
+	if aliases == nil {
+		if aliases, err = readAliases(); err != nil {
+			return false, backups.restore(err)
+		}
+	} else {
+		rendered["aliases"] = aliases.Render()
+	}
+	if err = aliases.Validate(resolveInclude(included)); err != nil {
+		return false, backups.restore(err)
+	}
+
+	for _, name := range names {
 		recv := temp + "/" + name + ".recv"
-		if err := update(recv, line); err != nil {
-			return err
+		if err := ioutil.WriteFile(recv, []byte(rendered[name]), 0644); err != nil {
+			return false, backups.restore(err)
+		}
+		if err := commit(temp+"/"+name+".head", recv, temp+"/"+name+".staged"); err != nil {
+			return false, backups.restore(err)
 		}
+	}
 
-		if err := smtpCommit(name, recv, temp, forward, aliases); err != nil {
-			return err
+	changed := len(moved) > 0
+	for _, name := range names {
+		staged := temp + "/" + name + ".staged"
+		same, err := sameChecksum(staged, smtpConf(name))
+		if err != nil {
+			return false, backups.restore(err)
+		}
+		if same {
+			continue
+		}
+		data, err := ioutil.ReadFile(staged)
+		if err != nil {
+			return false, backups.restore(err)
 		}
+		if err = ioutil.WriteFile(smtpConf(name), data, 0644); err != nil {
+			return false, backups.restore(err)
+		}
+		changed = true
+	}
+	if !changed {
+		return false, nil
 	}
 
 	if err := newaliases(); err != nil {
-		return err
+		return false, backups.restore(err)
 	}
 
-	return nil
+	return true, nil
 }
 
-func (s *SmtpSlice) UserDelete() error {
-	lines := s.Data
+// smtpConf is the promoted file, aliases lives in the aliases path and the forward files in the forward path
+func smtpConf(name string) string {
+	if name == "aliases" {
+		return SmtpSettings.Path.Aliases + "/" + name
+	}
 
-	temp := SmtpSettings.Path.Temp
-	forward := SmtpSettings.Path.Forward
-	aliases := SmtpSettings.Path.Aliases
-	for name, line := range lines {
-		recv := temp + "/" + name + ".recv"
-		if err := remove(recv, line); err != nil {
-			return err
+	return SmtpSettings.Path.Forward + "/" + name
+}
+
+func readAliases() (*mail.Aliases, error) {
+	recvData, err := ioutil.ReadFile(SmtpSettings.Path.Temp + "/aliases.recv")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return parseAliases(string(recvData))
+}
+
+func parseAliases(recv string) (*mail.Aliases, error) {
+	headData, err := ioutil.ReadFile(SmtpSettings.Path.Temp + "/aliases.head")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return mail.ParseAliases(string(headData), recv)
+}
+
+func readTargets(file string) ([]mail.Target, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return mail.ParseForward(string(data))
+}
+
+// resolveInclude reads the targets of included files, the forward files being changed are taken from includes
+func resolveInclude(includes map[string][]mail.Target) mail.Resolver {
+	return func(path string) []mail.Target {
+		if targets, ok := includes[path]; ok {
+			return targets
 		}
+		targets, err := readTargets(path)
+		if err != nil {
+			return nil
+		}
+		return targets
+	}
+}
 
-		if err := smtpCommit(name, recv, temp, forward, aliases); err != nil {
-			return err
+func withoutTarget(targets []mail.Target, target mail.Target) []mail.Target {
+	var kept []mail.Target
+	for _, existing := range targets {
+		if !existing.Equal(target) {
+			kept = append(kept, existing)
 		}
 	}
 
-	if err := newaliases(); err != nil {
-		return err
+	return kept
+}
+
+// aliasName trims the colon of a "name:" key sent by the legacy user endpoints
+func aliasName(line string) string {
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), ":"))
+}
+
+func validForwardName(name string) error {
+	if name == "" || name == "aliases" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return errors.New("Invalid forward name: " + name)
 	}
 
 	return nil
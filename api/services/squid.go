@@ -8,10 +8,16 @@ import (
 
 type Squid struct {
 	Enabled bool
+	Config  string
 	Path    struct {
 		Prod string
 		Temp string
 	}
+	Acls []SquidAcl
+	Log  struct {
+		Path      string
+		TailBytes int64 `yaml:"tail_bytes"`
+	}
 }
 
 type SquidString struct {
@@ -20,26 +26,40 @@ type SquidString struct {
 
 var SquidSettings Squid
 
-func (s *SquidString) Download() error {
-	lines := s.Data
-	for name, line := range lines {
+// Download assembles the files next to their recv and installs only those that differ from prod, squid is
+// reconfigured when at least one of them changed and the old files are restored when the reconfigure fails
+func (s *SquidString) Download() (bool, error) {
+	changed := make(map[string]string)
+	for name, line := range s.Data {
 		recv := SquidSettings.Path.Temp + "/" + name + ".recv"
 		if err := ioutil.WriteFile(recv, []byte(line.(string)), 0644); err != nil {
-			return err
+			return false, err
 		}
 
 		head := SquidSettings.Path.Temp + "/" + name + ".head"
-		conf := SquidSettings.Path.Prod + "/" + name
+		conf := SquidSettings.Path.Temp + "/" + name
 		if err := commit(head, recv, conf); err != nil {
-			return err
+			return false, err
+		}
+
+		prod := SquidSettings.Path.Prod + "/" + name
+		same, err := sameChecksum(conf, prod)
+		if err != nil {
+			return false, err
+		}
+		if !same {
+			changed[prod] = conf
 		}
 	}
 
-	if err := restartSquid(); err != nil {
-		return err
+	if len(changed) == 0 {
+		return false, nil
+	}
+	if err := install(changed, restartSquid); err != nil {
+		return false, err
 	}
 
-	return nil
+	return true, nil
 }
 
 func restartSquid() error {
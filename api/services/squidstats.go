@@ -0,0 +1,64 @@
+package services
+
+import (
+	"agent/api/squid"
+	"errors"
+	"os"
+	"time"
+)
+
+// SquidStats selects the window of the access.log statistics, Since and Until are RFC 3339 times
+type SquidStats struct {
+	Since string `json:"since" form:"since"`
+	Until string `json:"until" form:"until"`
+	Limit int    `json:"limit" form:"limit"`
+}
+
+const (
+	squidLogTailBytes = 64 * 1024 * 1024
+	squidStatsLimit   = 10
+	squidStatsWindow  = 24 * time.Hour
+)
+
+// Stats aggregates the tail of access.log, the window is the last day when Since is empty, the stats are
+// marked truncated when the tail starts after Since
+func (s *SquidStats) Stats() (squid.Stats, error) {
+	until := time.Time{}
+	since := time.Now().Add(-squidStatsWindow)
+	var err error
+	if s.Since != "" {
+		if since, err = time.Parse(time.RFC3339, s.Since); err != nil {
+			return squid.Stats{}, err
+		}
+	}
+	if s.Until != "" {
+		if until, err = time.Parse(time.RFC3339, s.Until); err != nil {
+			return squid.Stats{}, err
+		}
+	}
+	limit := s.Limit
+	if limit <= 0 {
+		limit = squidStatsLimit
+	}
+
+	if SquidSettings.Log.Path == "" {
+		return squid.Stats{}, errors.New("Squid access log path is not configured")
+	}
+	tail := SquidSettings.Log.TailBytes
+	if tail <= 0 {
+		tail = squidLogTailBytes
+	}
+	info, err := os.Stat(SquidSettings.Log.Path)
+	if err != nil {
+		return squid.Stats{}, err
+	}
+	text, err := tailFile(SquidSettings.Log.Path, tail)
+	if err != nil {
+		return squid.Stats{}, err
+	}
+
+	stats := squid.Aggregate(text, since, until, limit)
+	stats.Truncated = info.Size() > tail && stats.Oldest.After(since)
+
+	return stats, nil
+}
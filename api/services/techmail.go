@@ -0,0 +1,23 @@
+package services
+
+// Techmail is a set of files in prod, when Files declares any only those are accepted
+type Techmail struct {
+	Enabled bool
+	Path    struct {
+		Prod string
+		Temp string
+	}
+	Files []FileRule
+}
+
+var TechmailSettings Techmail
+
+// TechmailModule is the techmail section as a module, its files are prepended with their head like before
+func TechmailModule() Module {
+	return Module{
+		Name:    "techmail",
+		Path:    TechmailSettings.Path,
+		Files:   TechmailSettings.Files,
+		anyFile: len(TechmailSettings.Files) == 0,
+	}
+}
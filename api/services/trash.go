@@ -0,0 +1,365 @@
+package services
+
+import (
+	"agent/api/backup"
+	"agent/api/samba"
+	"agent/api/zfs"
+	"encoding/base64"
+	"errors"
+	"github.com/getsentry/sentry-go"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// user properties kept on a trashed dataset, they survive the rename and are needed to undelete or purge it
+const (
+	trashDeleted     = "agent:deleted"
+	trashOrigin      = "agent:origin"
+	trashMountpoint  = "agent:mountpoint"
+	trashShare       = "agent:share"
+	trashSection     = "agent:section"
+	trashServer      = "agent:backup_server"
+	trashRemote      = "agent:backup_fs"
+	trashRemoteFrom  = "agent:backup_origin"
+	trashStampLayout = "20060102T150405"
+	trashDefaultName = "trash"
+)
+
+type TrashedShare struct {
+	ZfsPath    string    `json:"zfs_path" form:"zfs_path"`
+	Origin     string    `json:"origin"`
+	Mountpoint string    `json:"mountpoint"`
+	Share      string    `json:"share"`
+	Server     string    `json:"backup_server"`
+	Remote     string    `json:"backup_fs"`
+	RemoteFrom string    `json:"backup_origin"`
+	Deleted    time.Time `json:"deleted"`
+	Purge      time.Time `json:"purge"`
+}
+
+// StartTrashPurge destroys the trashed shares whose grace period is over once an hour
+func StartTrashPurge() {
+	if ShareSettings.Trash.GraceDays <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			if err := PurgeTrash(); err != nil {
+				sentry.CaptureException(err)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// moveToTrash snapshots the dataset and renames it into the trash namespace of its pool, the remote copy goes to the trash of the backup pool
+func moveToTrash(zfsPath string, name string, section *samba.Section, server string, remote string) error {
+	client := zfsClient()
+	properties, err := client.Get(zfsPath, "mountpoint")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	stamp := now.Format(trashStampLayout)
+	if name == "" {
+		name = zfsPath[strings.LastIndex(zfsPath, "/")+1:]
+	}
+	trashed := trashRoot(zfsPath) + "/" + name + "-" + stamp
+
+	remoteTrashed := ""
+	if server != "" && remote != "" {
+		target := trashRoot(remote) + "/" + name + "-" + stamp
+		moved, err := renameRemote(server, remote, target)
+		if err != nil {
+			return err
+		}
+		if moved {
+			remoteTrashed = target
+		}
+	}
+
+	output, err := runner.Run(zfs.Binary, "snapshot", zfsPath+"@deleted-"+stamp)
+	if err != nil {
+		return restoreRemote(server, remoteTrashed, remote, errors.New(err.Error()+": "+string(output)+" - Snapshot: "+zfsPath))
+	}
+
+	output, err = runner.Run(zfs.Binary, "rename", "-p", zfsPath, trashed)
+	if err != nil {
+		return restoreRemote(server, remoteTrashed, remote, errors.New(err.Error()+": "+string(output)+" - Trash: "+zfsPath))
+	}
+
+	args := []string{"set",
+		"mountpoint=none",
+		trashDeleted + "=" + strconv.FormatInt(now.Unix(), 10),
+		trashOrigin + "=" + zfsPath,
+		trashMountpoint + "=" + properties["mountpoint"],
+		trashShare + "=" + name,
+	}
+	if section != nil {
+		args = append(args, trashSection+"="+base64.StdEncoding.EncodeToString([]byte(section.Render())))
+	}
+	if remoteTrashed != "" {
+		args = append(args, trashServer+"="+server, trashRemote+"="+remoteTrashed, trashRemoteFrom+"="+remote)
+	}
+	output, err = runner.Run(zfs.Binary, append(args, trashed)...)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output) + " - Trash: " + trashed)
+	}
+
+	return nil
+}
+
+// renameRemote renames the copy on the backup server, it reports false when the share was never replicated
+func renameRemote(server string, remote string, target string) (bool, error) {
+	transport, err := backup.Open(server)
+	if err != nil {
+		return false, err
+	}
+	defer transport.Close()
+
+	exists, err := transport.Exists(remote)
+	if err != nil || !exists {
+		return false, err
+	}
+	exists, err = transport.Exists(target)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, errors.New("Remote dataset already exists - Target: " + target)
+	}
+
+	if err = transport.Rename(remote, target); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// restoreRemote undoes renameRemote when renaming the local dataset failed
+func restoreRemote(server string, trashed string, remote string, err error) error {
+	if trashed == "" {
+		return err
+	}
+	if _, restoreErr := renameRemote(server, trashed, remote); restoreErr != nil {
+		return errors.New(err.Error() + "; " + restoreErr.Error())
+	}
+
+	return err
+}
+
+// trashRoot is the trash namespace in the pool of the dataset
+func trashRoot(zfsPath string) string {
+	name := ShareSettings.Trash.Dataset
+	if name == "" {
+		name = trashDefaultName
+	}
+
+	return strings.SplitN(zfsPath, "/", 2)[0] + "/" + name
+}
+
+// TrashedShares lists the datasets in the trash namespaces, the oldest first
+func TrashedShares() ([]TrashedShare, error) {
+	client := zfsClient()
+	deleted, err := client.Property(trashDeleted, "filesystem")
+	if err != nil {
+		return nil, err
+	}
+
+	shares := []TrashedShare{}
+	for name, value := range deleted {
+		if !strings.HasPrefix(name, trashRoot(name)+"/") {
+			continue
+		}
+		unix, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		properties, err := client.Get(name, trashOrigin, trashMountpoint, trashShare, trashServer, trashRemote, trashRemoteFrom)
+		if err != nil {
+			return nil, err
+		}
+
+		share := TrashedShare{
+			ZfsPath:    name,
+			Origin:     trashValue(properties[trashOrigin]),
+			Mountpoint: trashValue(properties[trashMountpoint]),
+			Share:      trashValue(properties[trashShare]),
+			Server:     trashValue(properties[trashServer]),
+			Remote:     trashValue(properties[trashRemote]),
+			RemoteFrom: trashValue(properties[trashRemoteFrom]),
+			Deleted:    time.Unix(unix, 0),
+		}
+		if ShareSettings.Trash.GraceDays > 0 {
+			share.Purge = share.Deleted.AddDate(0, 0, ShareSettings.Trash.GraceDays)
+		}
+		shares = append(shares, share)
+	}
+
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].Deleted.Before(shares[j].Deleted)
+	})
+
+	return shares, nil
+}
+
+// Undelete renames the trashed dataset back to its origin and restores the share section of smb.conf
+func (t *TrashedShare) Undelete() error {
+	share, err := trashedShare(t.ZfsPath)
+	if err != nil {
+		return err
+	}
+	if share.Origin == "" {
+		return errors.New("Trashed share has no origin - Trash: " + share.ZfsPath)
+	}
+
+	client := zfsClient()
+	exists, err := client.Exists(share.Origin)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.New("Dataset already exists - Origin: " + share.Origin)
+	}
+
+	properties, err := client.Get(share.ZfsPath, trashSection)
+	if err != nil {
+		return err
+	}
+	var sections []*samba.Section
+	if encoded := trashValue(properties[trashSection]); encoded != "" {
+		text, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+		if sections, err = samba.ParseSections(string(text)); err != nil {
+			return err
+		}
+	}
+
+	remoteRestored := ""
+	if share.Server != "" && share.Remote != "" && share.RemoteFrom != "" {
+		moved, err := renameRemote(share.Server, share.Remote, share.RemoteFrom)
+		if err != nil {
+			return err
+		}
+		if moved {
+			remoteRestored = share.RemoteFrom
+		}
+	}
+
+	output, err := runner.Run(zfs.Binary, "rename", "-p", share.ZfsPath, share.Origin)
+	if err != nil {
+		return restoreRemote(share.Server, remoteRestored, share.Remote, errors.New(err.Error()+": "+string(output)+" - Undelete: "+share.ZfsPath))
+	}
+
+	if share.Mountpoint != "" {
+		output, err = runner.Run(zfs.Binary, "set", "mountpoint="+share.Mountpoint, share.Origin)
+		if err != nil {
+			return errors.New(err.Error() + ": " + string(output) + " - Undelete: " + share.Origin)
+		}
+	}
+
+	for _, property := range []string{trashDeleted, trashOrigin, trashMountpoint, trashShare, trashSection, trashServer, trashRemote, trashRemoteFrom} {
+		output, err = runner.Run(zfs.Binary, "inherit", property, share.Origin)
+		if err != nil {
+			return errors.New(err.Error() + ": " + string(output) + " - Undelete: " + share.Origin)
+		}
+	}
+
+	if len(sections) == 0 {
+		return nil
+	}
+
+	return changeShares(func(conf *samba.Conf) error {
+		for _, section := range sections {
+			if err := conf.Add(section); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// PurgeTrash destroys the trashed shares and their remote copies once the grace period is over
+func PurgeTrash() error {
+	if ShareSettings.Trash.GraceDays <= 0 {
+		return nil
+	}
+
+	shares, err := TrashedShares()
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	now := time.Now()
+	for _, share := range shares {
+		if now.Before(share.Purge) {
+			continue
+		}
+		if err = share.purge(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// purge destroys the dataset, the remote copy only when it was renamed into the trash of the backup pool
+func (t *TrashedShare) purge() error {
+	if t.Server != "" && strings.HasPrefix(t.Remote, trashRoot(t.Remote)+"/") {
+		transport, err := backup.Open(t.Server)
+		if err != nil {
+			return err
+		}
+		defer transport.Close()
+
+		exists, err := transport.Exists(t.Remote)
+		if err != nil {
+			return err
+		}
+		if exists {
+			if err = transport.Destroy(t.Remote); err != nil {
+				return err
+			}
+		}
+	}
+
+	output, err := runner.Run(zfs.Binary, "destroy", "-r", t.ZfsPath)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output) + " - Purge: " + t.ZfsPath)
+	}
+
+	return nil
+}
+
+func trashedShare(zfsPath string) (TrashedShare, error) {
+	shares, err := TrashedShares()
+	if err != nil {
+		return TrashedShare{}, err
+	}
+	for _, share := range shares {
+		if share.ZfsPath == zfsPath {
+			return share, nil
+		}
+	}
+
+	return TrashedShare{}, errors.New("Share is not in trash - Trash: " + zfsPath)
+}
+
+// trashValue maps the "-" of an unset zfs property to empty
+func trashValue(value string) string {
+	if value == "-" {
+		return ""
+	}
+
+	return value
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type SambaUser struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type SambaUserInfo struct {
+	Name            string `json:"name"`
+	FullName        string `json:"full_name"`
+	Flags           string `json:"flags"`
+	Disabled        bool   `json:"disabled"`
+	PasswordLastSet string `json:"password_last_set"`
+}
+
+type SambaGroup struct {
+	Name    string   `json:"name"`
+	User    string   `json:"user"`
+	Members []string `json:"members"`
+}
+
+var accountName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// Create adds the user to the samba passdb, a unix account without home and shell is created when missing
+func (u *SambaUser) Create() error {
+	if err := validateAccount(u.Name); err != nil {
+		return err
+	}
+	if u.Password == "" {
+		return errors.New("Password is empty")
+	}
+
+	if _, err := runner.Run("/usr/bin/id", "-u", u.Name); err != nil {
+		output, err := runner.Run("/usr/sbin/useradd", "-M", "-s", "/sbin/nologin", u.Name)
+		if err != nil {
+			return errors.New(err.Error() + ": " + string(output))
+		}
+	}
+
+	output, err := runner.Stream(strings.NewReader(u.Password+"\n"+u.Password+"\n"), "/usr/bin/pdbedit", "-a", "-t", "-u", u.Name)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+func (u *SambaUser) Disable() error {
+	if err := validateAccount(u.Name); err != nil {
+		return err
+	}
+
+	output, err := runner.Run("/usr/bin/smbpasswd", "-d", u.Name)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+func (u *SambaUser) Delete() error {
+	if err := validateAccount(u.Name); err != nil {
+		return err
+	}
+
+	output, err := runner.Run("/usr/bin/pdbedit", "-x", "-u", u.Name)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+func (u *SambaUser) ResetPassword() error {
+	if err := validateAccount(u.Name); err != nil {
+		return err
+	}
+	if u.Password == "" {
+		return errors.New("Password is empty")
+	}
+
+	output, err := runner.Stream(strings.NewReader(u.Password+"\n"+u.Password+"\n"), "/usr/bin/smbpasswd", "-s", u.Name)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+func SambaUsers() ([]SambaUserInfo, error) {
+	output, err := runner.Run("/usr/bin/pdbedit", "-L", "-v")
+	if err != nil {
+		return nil, errors.New(err.Error() + ": " + string(output))
+	}
+
+	return parsePdbedit(string(output)), nil
+}
+
+func (g *SambaGroup) Create() error {
+	if err := validateAccount(g.Name); err != nil {
+		return err
+	}
+
+	output, err := runner.Run("/usr/sbin/groupadd", g.Name)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+func (g *SambaGroup) Delete() error {
+	if err := validateAccount(g.Name); err != nil {
+		return err
+	}
+
+	output, err := runner.Run("/usr/sbin/groupdel", g.Name)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+func (g *SambaGroup) AddMember() error {
+	return g.member("-a")
+}
+
+func (g *SambaGroup) RemoveMember() error {
+	return g.member("-d")
+}
+
+func (g *SambaGroup) member(action string) error {
+	if err := validateAccount(g.Name); err != nil {
+		return err
+	}
+	if err := validateAccount(g.User); err != nil {
+		return err
+	}
+
+	output, err := runner.Run("/usr/bin/gpasswd", action, g.User, g.Name)
+	if err != nil {
+		return errors.New(err.Error() + ": " + string(output))
+	}
+
+	return nil
+}
+
+// SambaGroups returns the local groups referenced as @group in valid users of the shares
+func SambaGroups() ([]SambaGroup, error) {
+	shares, err := readShares()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, share := range shares.Shares {
+		for _, validUser := range share.ValidUsers {
+			name := strings.TrimLeft(validUser, "@+&")
+			if name != validUser && accountName.MatchString(name) {
+				names[name] = true
+			}
+		}
+	}
+
+	var groups []SambaGroup
+	for name := range names {
+		group := SambaGroup{Name: name, Members: []string{}}
+		output, err := runner.Run("/usr/bin/getent", "group", name)
+		if err == nil {
+			fields := strings.Split(strings.TrimSpace(string(output)), ":")
+			if len(fields) == 4 && fields[3] != "" {
+				group.Members = strings.Split(fields[3], ",")
+			}
+		}
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	return groups, nil
+}
+
+// parsePdbedit parses pdbedit -L -v, accounts are separated by dashed lines
+func parsePdbedit(output string) []SambaUserInfo {
+	var users []SambaUserInfo
+	var current *SambaUserInfo
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "---") {
+			current = nil
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "Unix username":
+			users = append(users, SambaUserInfo{Name: value})
+			current = &users[len(users)-1]
+		case "Full Name":
+			if current != nil {
+				current.FullName = value
+			}
+		case "Account Flags":
+			if current != nil {
+				current.Flags = value
+				current.Disabled = strings.Contains(strings.Trim(value, "[]"), "D")
+			}
+		case "Password last set":
+			if current != nil {
+				current.PasswordLastSet = value
+			}
+		}
+	}
+
+	return users
+}
+
+func validateAccount(name string) error {
+	if !accountName.MatchString(name) {
+		return errors.New("Invalid account name: " + name)
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package squid
+
+import (
+	"bufio"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request is a line of access.log in the native format:
+// time elapsed client code/status bytes method url user hierarchy/peer type
+type Request struct {
+	Time    time.Time
+	Elapsed int64
+	Client  string
+	Code    string
+	Status  int
+	Bytes   int64
+	Method  string
+	Url     string
+	User    string
+}
+
+// Count is the number of requests and bytes of a domain or a client
+type Count struct {
+	Name     string `json:"name"`
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Stats covers the requests of the window from Since to Until, First and Last are the times of the first and
+// the last request counted and Oldest of the first request of the log text, Truncated is set by the caller
+// when the text does not reach back to Since
+type Stats struct {
+	Since          time.Time `json:"since"`
+	Until          time.Time `json:"until"`
+	First          time.Time `json:"first"`
+	Last           time.Time `json:"last"`
+	Oldest         time.Time `json:"oldest"`
+	Truncated      bool      `json:"truncated"`
+	Requests       int64     `json:"requests"`
+	Bytes          int64     `json:"bytes"`
+	Hits           int64     `json:"hits"`
+	HitRatio       float64   `json:"hit_ratio"`
+	ByteHitRatio   float64   `json:"byte_hit_ratio"`
+	Denied         int64     `json:"denied"`
+	TopDomains     []Count   `json:"top_domains"`
+	TopClients     []Count   `json:"top_clients"`
+	TopClientBytes []Count   `json:"top_client_bytes"`
+	TopDenied      []Count   `json:"top_denied"`
+}
+
+// ParseAccess parses a native access.log line, false is returned for anything else
+func ParseAccess(line string) (Request, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 7 {
+		return Request{}, false
+	}
+
+	stamp, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return Request{}, false
+	}
+	code, status, found := strings.Cut(fields[3], "/")
+	if !found {
+		return Request{}, false
+	}
+
+	request := Request{
+		Time:   time.Unix(0, int64(stamp*float64(time.Second))),
+		Client: fields[2],
+		Code:   code,
+		Method: fields[5],
+		Url:    fields[6],
+	}
+	request.Elapsed, _ = strconv.ParseInt(fields[1], 10, 64)
+	request.Status, _ = strconv.Atoi(status)
+	request.Bytes, _ = strconv.ParseInt(fields[4], 10, 64)
+	if len(fields) > 7 {
+		request.User = fields[7]
+	}
+
+	return request, true
+}
+
+// Domain returns the host of the url, CONNECT requests carry host:port
+func (r Request) Domain() string {
+	if r.Method == "CONNECT" || !strings.Contains(r.Url, "://") {
+		host := r.Url
+		if i := strings.LastIndex(host, ":"); i > 0 && !strings.HasSuffix(host, "]") {
+			host = host[:i]
+		}
+		return strings.ToLower(strings.Trim(host, "[]"))
+	}
+
+	parsed, err := url.Parse(r.Url)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(parsed.Hostname())
+}
+
+func (r Request) IsHit() bool {
+	return strings.Contains(r.Code, "HIT")
+}
+
+func (r Request) IsDenied() bool {
+	return strings.Contains(r.Code, "DENIED")
+}
+
+// Aggregate counts the requests of the window, a zero since or until leaves the window open, top lists hold limit entries
+func Aggregate(text string, since time.Time, until time.Time, limit int) Stats {
+	stats := Stats{Since: since, Until: until}
+	domains := make(map[string]*Count)
+	clients := make(map[string]*Count)
+	denied := make(map[string]*Count)
+	var hitBytes int64
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		request, ok := ParseAccess(scanner.Text())
+		if !ok {
+			continue
+		}
+		if stats.Oldest.IsZero() {
+			stats.Oldest = request.Time
+		}
+		if !since.IsZero() && request.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && request.Time.After(until) {
+			continue
+		}
+
+		if stats.First.IsZero() {
+			stats.First = request.Time
+		}
+		stats.Last = request.Time
+		stats.Requests++
+		stats.Bytes += request.Bytes
+		if request.IsHit() {
+			stats.Hits++
+			hitBytes += request.Bytes
+		}
+		domain := request.Domain()
+		if request.IsDenied() {
+			stats.Denied++
+			add(denied, domain, request.Bytes)
+		}
+		add(domains, domain, request.Bytes)
+		add(clients, request.Client, request.Bytes)
+	}
+
+	if stats.Requests > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(stats.Requests)
+	}
+	if stats.Bytes > 0 {
+		stats.ByteHitRatio = float64(hitBytes) / float64(stats.Bytes)
+	}
+	stats.TopDomains = top(domains, limit, byRequests)
+	stats.TopClients = top(clients, limit, byRequests)
+	stats.TopClientBytes = top(clients, limit, byBytes)
+	stats.TopDenied = top(denied, limit, byRequests)
+
+	return stats
+}
+
+func add(counts map[string]*Count, name string, bytes int64) {
+	count, ok := counts[name]
+	if !ok {
+		count = &Count{Name: name}
+		counts[name] = count
+	}
+	count.Requests++
+	count.Bytes += bytes
+}
+
+func byRequests(a *Count, b *Count) bool {
+	if a.Requests != b.Requests {
+		return a.Requests > b.Requests
+	}
+	return a.Name < b.Name
+}
+
+func byBytes(a *Count, b *Count) bool {
+	if a.Bytes != b.Bytes {
+		return a.Bytes > b.Bytes
+	}
+	return a.Name < b.Name
+}
+
+func top(counts map[string]*Count, limit int, less func(a *Count, b *Count) bool) []Count {
+	sorted := make([]*Count, 0, len(counts))
+	for _, count := range counts {
+		sorted = append(sorted, count)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+
+	result := []Count{}
+	for _, count := range sorted {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		result = append(result, *count)
+	}
+
+	return result
+}
@@ -0,0 +1,184 @@
+package zfs
+
+import (
+	"agent/api/command"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const Binary = "/sbin/zfs"
+
+var ErrNotExist = errors.New("dataset does not exist")
+
+type Dataset struct {
+	Name       string `json:"name"`
+	Guid       string `json:"guid"`
+	Used       int64  `json:"used"`
+	Available  int64  `json:"available"`
+	Referenced int64  `json:"referenced"`
+	Written    int64  `json:"written"`
+	RefQuota   int64  `json:"refquota"`
+	Mountpoint string `json:"mountpoint"`
+}
+
+type Snapshot struct {
+	Name     string    `json:"name"`
+	Guid     string    `json:"guid"`
+	Creation time.Time `json:"creation"`
+	Used     int64     `json:"used"`
+	Written  int64     `json:"written"`
+}
+
+// Client runs zfs through a command runner, so the same calls work on this host and on the backup server
+type Client struct {
+	Runner command.Runner
+	Binary string
+}
+
+const datasetColumns = "name,guid,used,available,referenced,written,refquota,mountpoint"
+
+var Local = Client{Runner: command.Local{}, Binary: Binary}
+
+func (c Client) Dataset(name string) (Dataset, error) {
+	output, err := c.Runner.Run(c.Binary, "list", "-Hp", "-o", datasetColumns, name)
+	if err != nil {
+		return Dataset{}, commandError(err, output)
+	}
+
+	datasets := ParseDatasets(string(output))
+	if len(datasets) == 0 {
+		return Dataset{}, ErrNotExist
+	}
+
+	return datasets[0], nil
+}
+
+func (c Client) Filesystems() ([]Dataset, error) {
+	output, err := c.Runner.Run(c.Binary, "list", "-Hp", "-t", "filesystem", "-o", datasetColumns)
+	if err != nil {
+		return nil, commandError(err, output)
+	}
+
+	return ParseDatasets(string(output)), nil
+}
+
+// Exists reports whether the dataset or snapshot exists, any other failure of zfs is returned as error
+func (c Client) Exists(name string) (bool, error) {
+	output, err := c.Runner.Run(c.Binary, "list", "-H", "-o", "name", name)
+	if err != nil {
+		if err = commandError(err, output); errors.Is(err, ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Snapshots returns the snapshots of the dataset from the oldest to the newest
+func (c Client) Snapshots(dataset string) ([]Snapshot, error) {
+	output, err := c.Runner.Run(c.Binary, "list", "-Hp", "-t", "snapshot", "-o", "name,guid,creation,used,written", "-s", "createtxg", "-d", "1", dataset)
+	if err != nil {
+		return nil, commandError(err, output)
+	}
+
+	return ParseSnapshots(string(output)), nil
+}
+
+func (c Client) Get(name string, properties ...string) (map[string]string, error) {
+	output, err := c.Runner.Run(c.Binary, "get", "-Hp", "-o", "property,value", strings.Join(properties, ","), name)
+	if err != nil {
+		return nil, commandError(err, output)
+	}
+
+	return ParseProperties(string(output)), nil
+}
+
+// Property returns the datasets of the type with the property set locally, mapped to its value
+func (c Client) Property(property string, types string) (map[string]string, error) {
+	output, err := c.Runner.Run(c.Binary, "get", "-Hp", "-t", types, "-s", "local", "-o", "name,value", property)
+	if err != nil {
+		return nil, commandError(err, output)
+	}
+
+	return ParseProperties(string(output)), nil
+}
+
+// ParseDatasets parses the rows of zfs list -Hp -o name,guid,used,available,referenced,written,refquota,mountpoint
+func ParseDatasets(output string) []Dataset {
+	var datasets []Dataset
+	for _, fields := range rows(output, 8) {
+		datasets = append(datasets, Dataset{
+			Name:       fields[0],
+			Guid:       fields[1],
+			Used:       ParseSize(fields[2]),
+			Available:  ParseSize(fields[3]),
+			Referenced: ParseSize(fields[4]),
+			Written:    ParseSize(fields[5]),
+			RefQuota:   ParseSize(fields[6]),
+			Mountpoint: fields[7],
+		})
+	}
+
+	return datasets
+}
+
+// ParseSnapshots parses the rows of zfs list -Hp -t snapshot -o name,guid,creation,used,written
+func ParseSnapshots(output string) []Snapshot {
+	var snapshots []Snapshot
+	for _, fields := range rows(output, 5) {
+		snapshots = append(snapshots, Snapshot{
+			Name:     fields[0],
+			Guid:     fields[1],
+			Creation: time.Unix(ParseSize(fields[2]), 0),
+			Used:     ParseSize(fields[3]),
+			Written:  ParseSize(fields[4]),
+		})
+	}
+
+	return snapshots
+}
+
+// ParseProperties parses the rows of zfs get -Hp -o property,value
+func ParseProperties(output string) map[string]string {
+	properties := make(map[string]string)
+	for _, fields := range rows(output, 2) {
+		properties[fields[0]] = fields[1]
+	}
+
+	return properties
+}
+
+// ParseSize parses a -p number, "-" and "none" are reported as zero
+func ParseSize(value string) int64 {
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return size
+}
+
+// rows splits the -H output into its columns, the last column keeps any tab of its value, like a mountpoint
+func rows(output string, columns int) [][]string {
+	var list [][]string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", columns)
+		if len(fields) != columns {
+			continue
+		}
+		list = append(list, fields)
+	}
+
+	return list
+}
+
+func commandError(err error, output []byte) error {
+	if strings.Contains(string(output), "does not exist") {
+		return ErrNotExist
+	}
+
+	return errors.New(err.Error() + ": " + string(output))
+}
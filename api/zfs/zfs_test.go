@@ -0,0 +1,183 @@
+package zfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readTestdata(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// recorded replays the output of a zfs call, the arguments of the last call are kept
+type recorded struct {
+	output []byte
+	err    error
+	args   []string
+}
+
+func (r *recorded) Run(name string, args ...string) ([]byte, error) {
+	r.args = args
+	return r.output, r.err
+}
+
+func (r *recorded) Stream(stdin io.Reader, name string, args ...string) ([]byte, error) {
+	return r.Run(name, args...)
+}
+
+func (r *recorded) Open(name string, args ...string) (io.ReadCloser, error) {
+	return nil, errors.New("not recorded")
+}
+
+func TestParseDatasets(t *testing.T) {
+	datasets := ParseDatasets(readTestdata(t, "list_filesystems.txt"))
+	if len(datasets) != 5 {
+		t.Fatalf("parsed %d datasets, want 5", len(datasets))
+	}
+
+	shares := datasets[1]
+	want := Dataset{
+		Name:       "tank/shares",
+		Guid:       "2289915290738547721",
+		Used:       1318998016,
+		Available:  4822736896,
+		Referenced: 1317994496,
+		Written:    16384,
+		RefQuota:   0,
+		Mountpoint: "/tank/shares",
+	}
+	if shares != want {
+		t.Errorf("dataset = %+v, want %+v", shares, want)
+	}
+
+	if mountpoint := datasets[2].Mountpoint; mountpoint != "/tank/shares/office\tdocs" {
+		t.Errorf("mountpoint with tab = %q", mountpoint)
+	}
+	if datasets[2].RefQuota != 1073741824 {
+		t.Errorf("refquota = %d, want 1073741824", datasets[2].RefQuota)
+	}
+
+	volume := datasets[3]
+	if volume.Written != 0 || volume.RefQuota != 0 || volume.Mountpoint != "-" {
+		t.Errorf("volume = %+v, want zero written and refquota and mountpoint -", volume)
+	}
+	if datasets[4].Mountpoint != "legacy" {
+		t.Errorf("mountpoint = %q, want legacy", datasets[4].Mountpoint)
+	}
+}
+
+func TestParseSnapshots(t *testing.T) {
+	snapshots := ParseSnapshots(readTestdata(t, "list_snapshots.txt"))
+	if len(snapshots) != 3 {
+		t.Fatalf("parsed %d snapshots, want 3", len(snapshots))
+	}
+
+	first := snapshots[0]
+	if first.Name != "tank/shares@2024-03-01" || first.Guid != "15207489416436404283" {
+		t.Errorf("snapshot = %+v", first)
+	}
+	if !first.Creation.Equal(time.Unix(1709251201, 0)) {
+		t.Errorf("creation = %v", first.Creation)
+	}
+	if first.Used != 458752 || first.Written != 1073741824 {
+		t.Errorf("used = %d written = %d", first.Used, first.Written)
+	}
+
+	if last := snapshots[2]; last.Used != 0 || last.Written != 0 {
+		t.Errorf("snapshot with - values = %+v, want zero used and written", last)
+	}
+}
+
+func TestParseProperties(t *testing.T) {
+	properties := ParseProperties(readTestdata(t, "get_properties.txt"))
+
+	want := map[string]string{
+		"used":        "1318998016",
+		"referenced":  "1317994496",
+		"refquota":    "0",
+		"mountpoint":  "/tank/shares",
+		"agent:share": "-",
+	}
+	if len(properties) != len(want) {
+		t.Fatalf("properties = %v, want %v", properties, want)
+	}
+	for name, value := range want {
+		if properties[name] != value {
+			t.Errorf("%s = %q, want %q", name, properties[name], value)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	sizes := map[string]int64{
+		"1318998016": 1318998016,
+		"0":          0,
+		"-":          0,
+		"none":       0,
+		"":           0,
+	}
+	for value, want := range sizes {
+		if size := ParseSize(value); size != want {
+			t.Errorf("ParseSize(%q) = %d, want %d", value, size, want)
+		}
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+
+	err := commandError(exitErr, []byte(readTestdata(t, "error_not_exist.txt")))
+	if !errors.Is(err, ErrNotExist) {
+		t.Errorf("error = %v, want ErrNotExist", err)
+	}
+
+	err = commandError(exitErr, []byte(readTestdata(t, "error_permission.txt")))
+	if errors.Is(err, ErrNotExist) || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("error = %v, want the permission error", err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	runner := &recorded{output: []byte(readTestdata(t, "error_not_exist.txt")), err: errors.New("exit status 1")}
+	client := Client{Runner: runner, Binary: Binary}
+
+	exists, err := client.Exists("tank/missing")
+	if exists || err != nil {
+		t.Errorf("Exists = %v, %v, want false without error", exists, err)
+	}
+
+	runner.output = []byte(readTestdata(t, "error_permission.txt"))
+	exists, err = client.Exists("tank/shares")
+	if exists || err == nil {
+		t.Errorf("Exists = %v, %v, want the permission error", exists, err)
+	}
+
+	runner.output, runner.err = []byte("tank/shares\n"), nil
+	exists, err = client.Exists("tank/shares")
+	if !exists || err != nil {
+		t.Errorf("Exists = %v, %v, want true", exists, err)
+	}
+}
+
+func TestDatasetNotExist(t *testing.T) {
+	runner := &recorded{output: []byte(readTestdata(t, "error_not_exist.txt")), err: errors.New("exit status 1")}
+	client := Client{Runner: runner, Binary: Binary}
+
+	if _, err := client.Dataset("tank/missing"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("error = %v, want ErrNotExist", err)
+	}
+	if strings.Join(runner.args, " ") != "list -Hp -o "+datasetColumns+" tank/missing" {
+		t.Errorf("args = %v", runner.args)
+	}
+}
@@ -6,11 +6,12 @@ require (
 	github.com/getsentry/sentry-go v0.19.0
 	github.com/go-ozzo/ozzo-routing/v2 v2.4.0
 	github.com/kelseyhightower/envconfig v1.4.0
+	golang.org/x/crypto v0.14.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
 	github.com/golang/gddo v0.0.0-20190904175337-72a348e765d2 // indirect
-	golang.org/x/sys v0.0.0-20220928140112-f11e5e49a4ec // indirect
-	golang.org/x/text v0.3.8 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
 )